@@ -1,25 +1,72 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"os/signal"
 
 	"github.com/nielsing/yar/robber"
 )
 
-func main() {
-	m := robber.NewMiddleware()
+// logError prints a given error and returns the exit code for errors.
+func logError(err error) int {
+	if usageErr, ok := err.(*robber.UsageError); ok {
+		fmt.Print(usageErr.Usage)
+	} else {
+		robber.NewLogger(false).LogFail("%s\n", err)
+	}
+	return robber.ExitError
+}
+
+// run runs yar and returns its' exit code.
+func run() int {
+	m, err := robber.NewMiddleware()
+	if err != nil {
+		return logError(err)
+	}
+	if m.Flags.CleanUpPresent || m.Flags.Hook == robber.HookInstall {
+		return robber.ExitClean
+	}
 
-	kill := make(chan bool)
-	cleanup := make(chan bool)
-	finished := make(chan bool)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	sigc := make(chan os.Signal, 2)
 	signal.Notify(sigc, os.Interrupt)
-	go robber.HandleSigInt(m, sigc, kill, finished, cleanup)
-	go robber.HandleSigInt(m, sigc, kill, finished, cleanup)
+	go robber.HandleSigInt(m, sigc, cancel)
 
-	m.Start(kill, finished, cleanup)
-	if m.Flags.SavePresent {
-		robber.SaveFindings(m)
+	if robber.IsTerminal(os.Stderr) && m.Flags.Hook == "" {
+		m.Logger.ShowProgress(m.Stats.Progress)
 	}
+	err = m.Start(ctx)
+	m.Logger.StopProgress()
+	if closeErr := m.Close(); err == nil {
+		err = closeErr
+	}
+
+	summary := m.Summary()
+	if m.Flags.Hook != "" {
+		m.Logger.LogHookResult(m.Flags.Hook, summary)
+	} else {
+		m.Logger.LogSkipped(summary.Skipped)
+		m.Logger.LogSummary(summary)
+	}
+	if *m.Flags.Summary != "" {
+		if err := robber.SaveSummary(summary, *m.Flags.Summary); err != nil {
+			m.Logger.LogWarn("Unable to save summary to %s: %s\n", *m.Flags.Summary, err)
+		}
+	}
+	if err == context.Canceled {
+		// The cache is kept along with the scan state of each repo, interrupted clones are removed
+		// by go-git and cached repos which turn out to be corrupted are recloned
+		return robber.ExitError
+	}
+	if err != nil {
+		return logError(err)
+	}
+	return summary.ExitCode()
+}
+
+func main() {
+	os.Exit(run())
 }
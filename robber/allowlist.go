@@ -0,0 +1,120 @@
+package robber
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// AllowMarker can be added to a line, i.e. within a comment, to tell yar that secrets on it are fine.
+const AllowMarker = "yar:ignore"
+
+// AllowRule holds an allowlist entry of the config. A finding of one of the rules the entry
+// applies to is allowed if its' file, secret or commit matches any of the entry's conditions.
+type AllowRule struct {
+	Reasons map[string]bool
+	Paths   []*regexp.Regexp
+	Secrets map[string]bool
+	Commits []string
+	Regexes []*regexp.Regexp
+}
+
+// globToRegex converts a path glob to a regex. A * matches anything but a path separator
+// while a ** matches anything. Globs without a path separator are matched against file names.
+func globToRegex(glob string) (*regexp.Regexp, error) {
+	var regex strings.Builder
+	if !strings.Contains(glob, "/") {
+		regex.WriteString("(^|/)")
+	} else {
+		regex.WriteString("^")
+	}
+	for i := 0; i < len(glob); i++ {
+		switch char := glob[i]; char {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				regex.WriteString(".*")
+				i++
+			} else {
+				regex.WriteString("[^/]*")
+			}
+		case '?':
+			regex.WriteString("[^/]")
+		default:
+			regex.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	regex.WriteString("$")
+	return regexp.Compile(regex.String())
+}
+
+// appliesTo checks whether the allowlist entry applies to a rule with the given reason.
+func (a *AllowRule) appliesTo(reason string) bool {
+	return len(a.Reasons) == 0 || a.Reasons[reason]
+}
+
+// namesCommit checks whether a given commit is one of the entry's commits.
+func (a *AllowRule) namesCommit(commitHash string) bool {
+	for _, commit := range a.Commits {
+		if strings.HasPrefix(commitHash, commit) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows checks whether a finding of a given rule, file, commit and secret is allowed by the entry.
+func (a *AllowRule) Allows(reason, filepath, commitHash, secret string) bool {
+	if !a.appliesTo(reason) {
+		return false
+	}
+	for _, path := range a.Paths {
+		if path.MatchString(filepath) {
+			return true
+		}
+	}
+	secretHash := sha256.Sum256([]byte(secret))
+	if a.Secrets[secret] || a.Secrets[hex.EncodeToString(secretHash[:])] {
+		return true
+	}
+	if a.namesCommit(commitHash) {
+		return true
+	}
+	for _, regex := range a.Regexes {
+		if regex.MatchString(secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed checks whether a finding is allowed, either by an allowlist entry of the config
+// or by the allow marker on the line the secret was found on.
+func Allowed(m *Middleware, reason string, diffObject *DiffObject, line string, secret string) bool {
+	if strings.Contains(line, AllowMarker) {
+		return true
+	}
+	commitHash := ""
+	if diffObject.Commit != nil {
+		commitHash = diffObject.Commit.Hash.String()
+	}
+	for _, allowRule := range m.Allowlist {
+		if allowRule.Allows(reason, *diffObject.Filepath, commitHash, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowlistedCommit checks whether an allowlist entry of the config names a given commit,
+// in which case some findings of the commit may be allowed which aren't in other commits.
+func allowlistedCommit(m *Middleware, commitHash plumbing.Hash) bool {
+	for _, allowRule := range m.Allowlist {
+		if allowRule.namesCommit(commitHash.String()) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,14 @@
 package robber
 
 import (
-	"strings"
+	"context"
+	"fmt"
+	"runtime"
 	"sync"
-	"sync/atomic"
 
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 )
 
@@ -17,138 +21,343 @@ const (
 	b64Threshold = 4.5
 	// Threshold for hex matching of entropy strings
 	hexThreshold = 3
+	// EntropyReason is the reason given for findings of the entropy analysis.
+	EntropyReason = "Entropy Check"
 )
 
-// AnalyzeEntropyDiff breaks a given diff into words and finds valid base64 and hex
-// strings within a word and finally runs an entropy check on the valid string.
-// Code taken from https://github.com/dxa4481/truffleHog.
-func AnalyzeEntropyDiff(m *Middleware, diffObject *DiffObject) {
-	words := strings.Fields(*diffObject.Diff)
-	for _, word := range words {
-		b64strings := FindValidStrings(word, B64chars)
-		hexstrings := FindValidStrings(word, Hexchars)
-		PrintEntropyFinding(b64strings, m, diffObject, b64Threshold)
-		PrintEntropyFinding(hexstrings, m, diffObject, hexThreshold)
+// ReportFinding tracks the exposure of a found secret and logs the finding, unless it is allowed,
+// it is in the baseline or it is a duplicate and duplicates are skipped. Line is the line of
+// the diff the secret starts on and lineNum its' index within the diff.
+func ReportFinding(m *Middleware, reason string, secret []int, lineNum int, diffObject *DiffObject, line string, contextDiff string) {
+	secretString := contextDiff[secret[0]:secret[1]]
+	if Allowed(m, reason, diffObject, line, secretString) {
+		return
 	}
+	finding := NewFinding(reason, secret, lineNum, diffObject)
+	if diffObject.Commit != nil {
+		finding.Exposure = m.TrackExposure(*diffObject.Reponame, secretString, finding, diffObject.Commit.Committer.When)
+	}
+	if m.Baseline != nil {
+		fingerprint := Fingerprint(reason, finding.RepoName, finding.CommitHash, finding.Filepath, secretString)
+		if m.Baseline.Contains(fingerprint) {
+			return
+		}
+	}
+	if *m.Flags.SkipDuplicates {
+		if m.SecretExists(*diffObject.Reponame, secretString) {
+			return
+		}
+		m.AddSecret(*diffObject.Reponame, secretString)
+	}
+	finding.Diff = contextDiff
+	if finding.Exposure != nil {
+		finding.Exposure.Reported = true
+	}
+	m.Report(finding)
 }
 
-// AnalyzeRegexDiff runs line by line on a given diff and runs each given regex rule on the line.
-func AnalyzeRegexDiff(m *Middleware, diffObject *DiffObject) {
-	lines := strings.Split(*diffObject.Diff, "\n")
-	numOfLines := len(lines)
-
-	for lineNum, line := range lines {
-		for _, rule := range m.Rules {
-			if found := rule.Regex.FindString(line); found != "" {
-				start, end := Max(0, lineNum-*m.Flags.Context), Min(numOfLines, lineNum+*m.Flags.Context+1)
-				context := lines[start:end]
-				newDiff := strings.Join(context, "\n")
-				secret := []int{strings.Index(newDiff, found)}
-				secret = append(secret, secret[0]+len(found))
-
-				secretString := newDiff[secret[0]:secret[1]]
-				if *m.Flags.SkipDuplicates && !m.SecretExists(*diffObject.Reponame, secretString) {
-					m.AddSecret(*diffObject.Reponame, secretString)
-					finding := NewFinding(rule.Reason, secret, diffObject)
-					m.Logger.LogFinding(finding, m, newDiff)
-				} else if !*m.Flags.SkipDuplicates {
-					finding := NewFinding(rule.Reason, secret, diffObject)
-					m.Logger.LogFinding(finding, m, newDiff)
-				}
-			}
+// ScanRepo opens a given repository and extracts all diffs from it for analysis.
+// The scan stops once the given context is done, in which case the scan state isn't saved.
+// Repositories which exceed the --repo-timeout or --max-repo-size flags are reported as skipped.
+func ScanRepo(ctx context.Context, m *Middleware, reponame string) error {
+	if m.Flags.Timeout == 0 {
+		return scanRepo(ctx, m, reponame)
+	}
+	repoCtx, cancel := context.WithTimeout(ctx, m.Flags.Timeout)
+	defer cancel()
+	err := scanRepo(repoCtx, m, reponame)
+	if ctx.Err() == nil && repoCtx.Err() == context.DeadlineExceeded {
+		m.AddSkipped(reponame, fmt.Sprintf("Scan took longer than %s, only part of it was scanned", m.Flags.Timeout))
+		return ErrSkipped
+	}
+	return err
+}
+
+func scanRepo(ctx context.Context, m *Middleware, reponame string) error {
+	repo, err := OpenRepo(ctx, m, reponame)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		switch err {
+		case transport.ErrEmptyRemoteRepository:
+			return err
+		case transport.ErrRepositoryNotFound:
+			return &NotFoundError{Name: reponame, Err: err}
+		case transport.ErrAuthenticationRequired, transport.ErrAuthorizationFailed:
+			return &AuthError{Err: err}
 		}
+		return fmt.Errorf("Unable to open repo %s: %w", reponame, err)
+	}
+
+	dir, _ := GetDir(reponame)
+	if m.Flags.MaxSize != 0 && tooLarge(m, reponame, getRepoSize(dir)) {
+		return ErrSkipped
+	}
+
+	// go-git isn't safe for concurrent use, so history is walked on a repository of its' own
+	history, err := git.PlainOpen(dir)
+	if err != nil {
+		return fmt.Errorf("Unable to open repo %s: %w", reponame, err)
+	}
+	state := LoadScanState(m, reponame)
+	commits, errc, err := GetCommits(ctx, m, history, reponame, state)
+	if err != nil {
+		return err
 	}
+
+	diffs := m.Diffs
+	if diffs == nil {
+		diffs = NewDiffCache()
+	}
+	if err := AnalyzeCommits(ctx, m, repo, reponame, commits, diffs, false); err != nil {
+		return err
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	CheckExposures(m, repo, reponame)
+	SaveScanState(m, reponame, state)
+	return nil
 }
 
-// AnalyzeRepo opens a given repository and extracts all diffs from it for later analysis.
-func AnalyzeRepo(m *Middleware, id int, repoch <-chan string, quit chan<- bool, done <-chan bool, wg *sync.WaitGroup) {
-	for {
-		select {
-		case reponame := <-repoch:
-			repo, err := OpenRepo(m, reponame)
-			if err != nil {
-				if err == transport.ErrEmptyRemoteRepository {
-					m.Logger.LogWarn("%s is empty\n", reponame)
-					atomic.AddInt32(m.RepoCount, -1)
-					if atomic.LoadInt32(m.RepoCount) == 0 {
-						quit <- true
-					}
-					continue
-				}
-				m.Logger.LogFail("Unable to open repo %s: %s\n", reponame, err)
-			}
+// detectedDiff holds a diff of a commit along with what the detectors found within it.
+type detectedDiff struct {
+	diffObject *DiffObject
+	matches    []*Match
+}
 
-			commits, err := GetCommits(m, repo, reponame)
-			if err != nil {
-				m.Logger.LogWarn("Unable to fetch commits for %s: %s\n", reponame, err)
-				return
-			}
+// detectedChange holds the detected diffs of a single change of a commit.
+type detectedChange struct {
+	change *object.Change
+	diffs  []*detectedDiff
+}
 
-			// Get all changes in correct order of commit history
-			for index := range commits {
-				commit := commits[len(commits)-index-1]
-				changes, err := GetCommitChanges(commit)
-				if err != nil {
-					m.Logger.LogWarn("Unable to get commit changes for hash %s: %s\n", commit.Hash, err)
-					continue
-				}
+// commitJob holds the changes of a commit whose diffs are waiting to be run through the
+// detectors by a commit worker, which closes done once it is finished.
+type commitJob struct {
+	hash    plumbing.Hash
+	changes []*detectedChange
+	done    chan struct{}
+}
 
-				for _, change := range changes {
-					diffs, filepath, err := GetDiffs(m, change, reponame)
-					if err != nil {
-						m.Logger.LogWarn("Unable to get diffs of %s: %s\n", change, err)
-						continue
-					}
-					for _, diff := range diffs {
-						diffObject := NewDiffObject(commit, &diff, &reponame, &filepath)
-						if *m.Flags.Both {
-							AnalyzeRegexDiff(m, diffObject)
-							AnalyzeEntropyDiff(m, diffObject)
-						} else if *m.Flags.Entropy {
-							AnalyzeEntropyDiff(m, diffObject)
-						} else {
-							AnalyzeRegexDiff(m, diffObject)
+// AnalyzeCommits analyzes the hunks the given commits add, and remove unless addedOnly is set, as
+// they come in. The diffs of the commits are run through the detectors by a pool of --commit-workers
+// workers while their findings are reported in the order the commits came in, which is oldest first.
+// Since go-git isn't safe for concurrent use the commits and their diffs are all loaded by a single
+// go routine. Only a few commits per worker are held in memory at once. Changes whose diff was already
+// analyzed according to the given DiffCache are skipped. Once the given context is done
+// the remaining commits aren't reported and the error of the context is returned.
+func AnalyzeCommits(ctx context.Context, m *Middleware, repo *git.Repository, reponame string,
+	commits <-chan *PendingCommit, diffs *DiffCache, addedOnly bool) error {
+	workers := *m.Flags.CommitWorkers
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	}
+
+	jobs := make(chan *commitJob)
+	// Jobs are queued in the order of the commits, which bounds how far workers can run ahead
+	queue := make(chan *commitJob, 2*workers)
+	wg := new(sync.WaitGroup)
+	for proc := 0; proc < workers; proc++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				for _, change := range job.changes {
+					for _, diff := range change.diffs {
+						if ctx.Err() == nil {
+							diff.matches = detectDiff(m, diff.diffObject)
 						}
 					}
 				}
+				close(job.done)
 			}
-			atomic.AddInt32(m.RepoCount, -1)
-			if atomic.LoadInt32(m.RepoCount) == 0 {
-				quit <- true
+		}()
+	}
+	go func() {
+		defer close(queue)
+		defer close(jobs)
+		for commit := range commits {
+			if ctx.Err() != nil {
+				return
 			}
-		case <-done:
-			wg.Done()
-			return
+			job := &commitJob{
+				hash:    commit.Hash,
+				changes: loadCommit(m, repo, commit.Hash, reponame, commit.Refs, diffs, addedOnly),
+				done:    make(chan struct{}),
+			}
+			select {
+			case queue <- job:
+			case <-ctx.Done():
+				return
+			}
+			jobs <- job
+		}
+	}()
+
+	for job := range queue {
+		<-job.done
+		if ctx.Err() != nil {
+			continue
+		}
+		m.Stats.AddCommit()
+		// Findings an allowlist entry allows only in this commit must not hide them in other commits
+		remember := !allowlistedCommit(m, job.hash)
+		for _, change := range job.changes {
+			// The diff may have been analyzed by an earlier commit while this one was in progress
+			if diffs.Has(change.change) || remember && diffs.Seen(change.change) {
+				continue
+			}
+			for _, diff := range change.diffs {
+				reportMatches(m, diff.matches, diff.diffObject)
+			}
+		}
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// loadCommit loads the commit of a given hash along with the diffs of the hunks it adds,
+// and removes unless addedOnly is set. Changes of blacklisted files and changes whose diff
+// was already analyzed according to the given DiffCache are left out.
+func loadCommit(m *Middleware, repo *git.Repository, hash plumbing.Hash, reponame string,
+	refs []string, diffs *DiffCache, addedOnly bool) []*detectedChange {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		m.Logger.LogWarn("Unable to get commit %s: %s\n", hash, err)
+		return nil
+	}
+	changes, err := GetCommitChanges(commit)
+	if err != nil {
+		m.Logger.LogWarn("Unable to get commit changes for hash %s: %s\n", commit.Hash, err)
+		return nil
+	}
+
+	var loaded []*detectedChange
+	for _, change := range changes {
+		// The diff of a blacklisted file isn't analyzed, so it can't be marked as seen
+		if blacklistedFile(m, changeName(change)) || diffs.Has(change) {
+			continue
+		}
+		hunks, filepath, err := GetDiffs(m, change, reponame)
+		if err != nil {
+			m.Logger.LogWarn("Unable to get diffs of %s: %s\n", change, err)
+			continue
+		}
+		loadedChange := &detectedChange{change: change}
+		for _, hunk := range hunks {
+			if hunk.Added || !addedOnly {
+				loadedChange.diffs = append(loadedChange.diffs, &detectedDiff{
+					diffObject: NewDiffObject(commit, hunk, &reponame, &filepath, refs),
+				})
+			}
+		}
+		loaded = append(loaded, loadedChange)
+	}
+	return loaded
+}
+
+// tooLarge checks whether a repository of a given size is larger than the --max-repo-size flag
+// allows, in which case it is reported as skipped.
+func tooLarge(m *Middleware, reponame string, size int64) bool {
+	if m.Flags.MaxSize == 0 || size <= m.Flags.MaxSize {
+		return false
+	}
+	m.AddSkipped(reponame, fmt.Sprintf("Size of %s exceeds %s", FormatSize(size), FormatSize(m.Flags.MaxSize)))
+	return true
+}
+
+// AnalyzeRepo receives sources and scans them until the channel of sources is closed.
+// Errors of a source are logged and collected before moving on to the next one,
+// unless the scan was cancelled in which case the remaining sources are skipped.
+func AnalyzeRepo(ctx context.Context, m *Middleware, id int, repoch <-chan Source, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for source := range repoch {
+		if ctx.Err() != nil {
+			continue
+		}
+		m.Stats.SetWorking(id, fmt.Sprint(source))
+		err := source.Scan(ctx, m)
+		switch {
+		case ctx.Err() != nil:
+		case err == nil:
+			m.Stats.AddScanned()
+		case err == transport.ErrEmptyRemoteRepository:
+			m.Stats.AddEmpty()
+		case err == ErrSkipped:
+		default:
+			m.Logger.LogWarn("%s\n", err)
+			m.AddError(err)
 		}
+		m.Stats.FinishWorking(id)
+	}
+}
+
+// sendSource sends a given source to the workers unless the scan is cancelled first.
+func sendSource(ctx context.Context, m *Middleware, repoch chan<- Source, source Source) error {
+	select {
+	case repoch <- source:
+		m.Stats.AddQueued()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+// keepListed logs and collects a RateLimitError of listing repositories or members, so the ones
+// listed before the limit was hit are still scanned while the scan fails, and returns any other error.
+func keepListed(m *Middleware, err error) error {
+	if _, ok := err.(*RateLimitError); ok {
+		m.Logger.LogWarn("%s\n", err)
+		m.AddError(err)
+		return nil
+	}
+	return err
+}
+
 // AnalyzeUser simply sends a GET request on githubs API for a given username
 // and starts and analysis of each of the user's repositories.
-func AnalyzeUser(m *Middleware, username string, repoch chan<- string) {
-	repos := GetUserRepos(m, username)
-	atomic.AddInt32(m.RepoCount, int32(len(repos)))
+func AnalyzeUser(ctx context.Context, m *Middleware, username string, repoch chan<- Source) error {
+	repos, err := GetUserRepos(ctx, m, username)
+	if err := keepListed(m, err); err != nil {
+		return err
+	}
 	for _, repo := range repos {
-		repoch <- *repo
+		if err := sendSource(ctx, m, repoch, &RepoSource{Name: *repo}); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // AnalyzeOrg simply sends two GET requests to githubs API, one for a given organizations
 // repositories and one for its' members.
-func AnalyzeOrg(m *Middleware, orgname string, repoch chan<- string) {
+func AnalyzeOrg(ctx context.Context, m *Middleware, orgname string, repoch chan<- Source) error {
 	var members []*string
 	if *m.Flags.IncludeMembers {
-		members = GetOrgMembers(m, orgname)
+		var err error
+		members, err = GetOrgMembers(ctx, m, orgname)
+		if err := keepListed(m, err); err != nil {
+			return err
+		}
 	} else {
 		members = []*string{}
 	}
-	repos := GetOrgRepos(m, orgname)
-	atomic.AddInt32(m.RepoCount, int32(len(repos)))
+	repos, err := GetOrgRepos(ctx, m, orgname)
+	if err := keepListed(m, err); err != nil {
+		return err
+	}
 
 	for _, repo := range repos {
-		repoch <- *repo
+		if err := sendSource(ctx, m, repoch, &RepoSource{Name: *repo}); err != nil {
+			return err
+		}
 	}
 	for _, member := range members {
-		AnalyzeUser(m, *member, repoch)
+		if err := AnalyzeUser(ctx, m, *member, repoch); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -0,0 +1,105 @@
+package robber
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Baseline holds previously saved findings which have already been triaged.
+// Findings matching a finding of the baseline are suppressed.
+type Baseline struct {
+	findings     []*jsonFinding
+	fingerprints map[string]bool
+}
+
+// Fingerprint identifies a finding by its' rule, repo, commit, file and a hash of the secret.
+func Fingerprint(reason, reponame, commitHash, filepath, secret string) string {
+	secretHash := sha256.Sum256([]byte(secret))
+	reponame = strings.Replace(reponame, ".git", "", 1)
+	return strings.Join([]string{reason, reponame, commitHash, filepath, hex.EncodeToString(secretHash[:])}, "\x00")
+}
+
+func jsonFingerprint(finding *jsonFinding) string {
+	return Fingerprint(finding.Reason, finding.RepoName, finding.CommitHash, finding.Filepath, finding.Secret)
+}
+
+// parseSavedFindings parses findings saved in either the JSON or the JSON Lines format.
+func parseSavedFindings(content []byte) ([]*jsonFinding, error) {
+	var findings []*jsonFinding
+	if !bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		err := json.Unmarshal(content, &findings)
+		return findings, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, len(content)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		finding := &jsonFinding{}
+		if err := json.Unmarshal(line, finding); err != nil {
+			return nil, err
+		}
+		findings = append(findings, finding)
+	}
+	return findings, scanner.Err()
+}
+
+// LoadBaseline loads the baseline file given with the --baseline flag.
+// A missing baseline file is only allowed if the baseline is being updated.
+func LoadBaseline(m *Middleware) error {
+	baseline := &Baseline{
+		findings:     []*jsonFinding{},
+		fingerprints: make(map[string]bool),
+	}
+	m.Baseline = baseline
+
+	content, err := ioutil.ReadFile(*m.Flags.Baseline)
+	if os.IsNotExist(err) && *m.Flags.UpdateBaseline {
+		return nil
+	} else if err != nil {
+		return &ConfigError{Msg: "Unable to read baseline " + *m.Flags.Baseline, Err: err}
+	}
+
+	findings, err := parseSavedFindings(content)
+	if err != nil {
+		return &ConfigError{Msg: "Unable to parse baseline " + *m.Flags.Baseline, Err: err}
+	}
+	for _, finding := range findings {
+		baseline.add(finding)
+	}
+	return nil
+}
+
+func (b *Baseline) add(finding *jsonFinding) {
+	fingerprint := jsonFingerprint(finding)
+	if b.fingerprints[fingerprint] {
+		return
+	}
+	b.fingerprints[fingerprint] = true
+	b.findings = append(b.findings, finding)
+}
+
+// Contains checks whether a finding with the given fingerprint is in the baseline.
+func (b *Baseline) Contains(fingerprint string) bool {
+	return b.fingerprints[fingerprint]
+}
+
+// UpdateBaseline adds all new findings to the baseline and rewrites the baseline file.
+func UpdateBaseline(m *Middleware) {
+	for _, finding := range m.Findings {
+		m.Baseline.add(newJSONFinding(finding, false))
+	}
+	content, _ := json.MarshalIndent(m.Baseline.findings, "", "  ")
+	if err := ioutil.WriteFile(*m.Flags.Baseline, content, 0644); err != nil {
+		m.Logger.LogWarn("Unable to update baseline %s: %s\n", *m.Flags.Baseline, err)
+	}
+}
@@ -3,6 +3,7 @@ package robber
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"regexp"
 )
@@ -20,17 +21,69 @@ Read here for more information: https://golang.org/pkg/regexp/syntax/
 // Config struct holds all config from the given JSON file.
 type Config struct {
 	Rules []struct {
-		Reason string `json:"Reason"`
-		Rule   string `json:"Rule"`
-		Noise  int    `json:"Noise"`
+		Reason    string   `json:"Reason"`
+		Rule      string   `json:"Rule"`
+		Noise     int      `json:"Noise"`
+		Multiline bool     `json:"Multiline"`
+		Keywords  []string `json:"Keywords"`
 	} `json:"Rules"`
 	FileBlacklist []string `json:"FileBlacklist"`
+	Allowlist     []struct {
+		Rules   []string `json:"Rules"`
+		Paths   []string `json:"Paths"`
+		Secrets []string `json:"Secrets"`
+		Commits []string `json:"Commits"`
+		Regexes []string `json:"Regexes"`
+	} `json:"Allowlist"`
 }
 
-// Rule struct holds a given regex rule with its' reason for matching.
+// Name of the capture group rules can use to mark which part of a match is the secret.
+const secretGroup = "secret"
+
+// Rule struct holds a given regex rule with its' reason for matching and noise level.
+// Multiline rules are run on whole hunks instead of single lines, so they can match secrets spanning lines.
+// SecretGroup is the index of the rule's secret capture group, or 0 if the whole match is the secret.
+// A rule is only run on diffs containing one of its' keywords, if it has any, see Prefilter.
 type Rule struct {
-	Reason string
-	Regex  *regexp.Regexp
+	Reason      string
+	Regex       *regexp.Regexp
+	Noise       int
+	Multiline   bool
+	SecretGroup int
+	Keywords    []string
+}
+
+// secretIndex returns the index of the capture group named secret within a given regex, or 0 if there is none.
+func secretIndex(regex *regexp.Regexp) int {
+	for index, name := range regex.SubexpNames() {
+		if name == secretGroup {
+			return index
+		}
+	}
+	return 0
+}
+
+// secretSpan returns the start and end of the secret within a given submatch of the rule.
+// The whole match is the secret unless the secret group of the rule took part in the match.
+func (r *Rule) secretSpan(submatch []int) (int, int) {
+	if start := submatch[2*r.SecretGroup]; start != -1 {
+		return start, submatch[2*r.SecretGroup+1]
+	}
+	return submatch[0], submatch[1]
+}
+
+// ruleNoise returns the noise level of the rule with the given reason. Rules of custom
+// detectors are given the same noise level as in SARIF logs.
+func ruleNoise(m *Middleware, reason string) int {
+	if reason == EntropyReason {
+		return entropyNoise
+	}
+	for _, rule := range m.Rules {
+		if rule.Reason == reason {
+			return rule.Noise
+		}
+	}
+	return customNoise
 }
 
 // ParseConfig parses a given config file, if there was none given
@@ -38,32 +91,44 @@ type Rule struct {
 //
 // ParseConfig first parses all rules in the config file below a given noiselevel
 // the default max noiselevel being 3.
-// Then it parses all regex rules for the file blacklist.
-func ParseConfig(m *Middleware) {
+// Then it parses all regex rules for the file blacklist and finally the allowlist.
+// A ConfigError is returned if the config file can't be read, contains invalid rules
+// or doesn't contain a rule which findings should fail on.
+func ParseConfig(m *Middleware) error {
 	var config Config
 	var rules []*Rule
 	var blacklist []*regexp.Regexp
+	var allowlist []*AllowRule
 
 	// Read contents of JSON file
+	defer m.Flags.Config.Close()
 	reader := bufio.NewReader(m.Flags.Config)
 	content, err := ioutil.ReadAll(reader)
 	if err != nil {
-		m.Logger.LogFail("Unable to read file %s: %s", m.Flags.Config.Name(), err)
+		return &ConfigError{Msg: "Unable to read file " + m.Flags.Config.Name(), Err: err}
 	}
 
 	// Parse JSON file and compile regex rules
-	json.Unmarshal([]byte(content), &config)
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		return &ConfigError{Msg: "Unable to parse file " + m.Flags.Config.Name(), Err: err}
+	}
+	reasons := map[string]bool{EntropyReason: true}
 	for _, rule := range config.Rules {
+		reasons[rule.Reason] = true
 		if rule.Noise > m.Flags.NoiseLevel.Upper || rule.Noise < m.Flags.NoiseLevel.Lower {
 			continue
 		}
 		regex, err := regexp.Compile(rule.Rule)
 		if err != nil {
-			m.Logger.LogFail(regexErrorMessage, rule.Reason, rule.Rule, err)
+			return &ConfigError{Msg: fmt.Sprintf(regexErrorMessage, rule.Reason, rule.Rule, err)}
 		}
 		rule := &Rule{
-			Reason: rule.Reason,
-			Regex:  regex,
+			Reason:      rule.Reason,
+			Regex:       regex,
+			Noise:       rule.Noise,
+			Multiline:   rule.Multiline,
+			SecretGroup: secretIndex(regex),
+			Keywords:    rule.Keywords,
 		}
 		rules = append(rules, rule)
 	}
@@ -71,11 +136,46 @@ func ParseConfig(m *Middleware) {
 	for _, fileRule := range config.FileBlacklist {
 		regex, err := regexp.Compile(fileRule)
 		if err != nil {
-			m.Logger.LogFail(regexErrorMessage, "File blacklist", fileRule, err)
+			return &ConfigError{Msg: fmt.Sprintf(regexErrorMessage, "File blacklist", fileRule, err)}
 		}
 		blacklist = append(blacklist, regex)
 	}
+	for _, entry := range config.Allowlist {
+		allowRule := &AllowRule{
+			Reasons: make(map[string]bool),
+			Secrets: make(map[string]bool),
+			Commits: entry.Commits,
+		}
+		for _, reason := range entry.Rules {
+			allowRule.Reasons[reason] = true
+		}
+		for _, secret := range entry.Secrets {
+			allowRule.Secrets[secret] = true
+		}
+		for _, path := range entry.Paths {
+			regex, err := globToRegex(path)
+			if err != nil {
+				return &ConfigError{Msg: fmt.Sprintf(regexErrorMessage, "Allowlist path", path, err)}
+			}
+			allowRule.Paths = append(allowRule.Paths, regex)
+		}
+		for _, rule := range entry.Regexes {
+			regex, err := regexp.Compile(rule)
+			if err != nil {
+				return &ConfigError{Msg: fmt.Sprintf(regexErrorMessage, "Allowlist regex", rule, err)}
+			}
+			allowRule.Regexes = append(allowRule.Regexes, regex)
+		}
+		allowlist = append(allowlist, allowRule)
+	}
+	for reason := range m.Flags.FailOnRules {
+		if !reasons[reason] {
+			return &ConfigError{Msg: "Unknown rule given to fail on: " + reason}
+		}
+	}
 	m.Rules = rules
+	m.Prefilter = NewPrefilter(rules)
 	m.Blacklist = blacklist
-	m.Flags.Config.Close()
+	m.Allowlist = allowlist
+	return nil
 }
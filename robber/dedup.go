@@ -0,0 +1,56 @@
+package robber
+
+import (
+	"sync"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// blobPair identifies the diff between two versions of a file by its' path and the hashes of
+// their blobs. The path is part of it since findings are allowed by path, so the same diff at
+// another path, i.e. in a copy of the file, is analyzed again. The hash of a missing version,
+// i.e. of a file which was added or deleted, is the zero hash.
+type blobPair struct {
+	path string
+	from plumbing.Hash
+	to   plumbing.Hash
+}
+
+// newBlobPair returns the blobPair of a given change.
+func newBlobPair(change *object.Change) blobPair {
+	return blobPair{path: changeName(change), from: change.From.TreeEntry.Hash, to: change.To.TreeEntry.Hash}
+}
+
+// DiffCache keeps track of the diffs between blobs which have already been analyzed. The same
+// diff is often found again in merges, cherry-picks and reverts of reverts, and only has to be
+// analyzed the first time, so its' findings are attributed to the commit which introduced it first.
+// It is safe to use from multiple go routines.
+type DiffCache struct {
+	sync.Mutex
+	seen map[blobPair]bool
+}
+
+// NewDiffCache returns an empty DiffCache.
+func NewDiffCache() *DiffCache {
+	return &DiffCache{seen: make(map[blobPair]bool)}
+}
+
+// Has checks whether the diff of a given change was already analyzed, without marking it.
+func (c *DiffCache) Has(change *object.Change) bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.seen[newBlobPair(change)]
+}
+
+// Seen marks the diff of a given change as analyzed and returns whether it already was.
+func (c *DiffCache) Seen(change *object.Change) bool {
+	c.Lock()
+	defer c.Unlock()
+	pair := newBlobPair(change)
+	if c.seen[pair] {
+		return true
+	}
+	c.seen[pair] = true
+	return false
+}
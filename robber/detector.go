@@ -0,0 +1,165 @@
+package robber
+
+import (
+	"sort"
+	"strings"
+)
+
+// Match is a secret a detector found within a diff. Start and End are the byte offsets
+// of the secret within the diff.
+type Match struct {
+	Reason string
+	Start  int
+	End    int
+}
+
+// Detector finds secrets within the diffs of a source.
+type Detector interface {
+	Detect(m *Middleware, diffObject *DiffObject) []*Match
+}
+
+// RegexDetector finds secrets by running the regex rules of the config on each line of a diff.
+// Multiline rules are run on the whole diff instead.
+type RegexDetector struct{}
+
+// Detect runs each regex rule on every line of a given diff, and each multiline rule on the whole diff.
+// Only the secret capture group of a rule is matched as the secret, if the rule has one.
+// Rules whose keywords don't appear in the diff are skipped.
+func (d *RegexDetector) Detect(m *Middleware, diffObject *DiffObject) []*Match {
+	rules := m.Rules
+	if m.Prefilter != nil {
+		candidates := m.Prefilter.Rules(*diffObject.Diff)
+		rules = make([]*Rule, 0, len(candidates))
+		for _, rule := range m.Rules {
+			if candidates[rule] {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	var matches []*Match
+	offset := 0
+	for _, line := range strings.Split(*diffObject.Diff, "\n") {
+		for _, rule := range rules {
+			if rule.Multiline {
+				continue
+			}
+			if found := rule.Regex.FindStringSubmatchIndex(line); found != nil {
+				if start, end := rule.secretSpan(found); start != end {
+					matches = append(matches, &Match{
+						Reason: rule.Reason,
+						Start:  offset + start,
+						End:    offset + end,
+					})
+				}
+			}
+		}
+		offset += len(line) + 1
+	}
+	for _, rule := range rules {
+		if !rule.Multiline {
+			continue
+		}
+		for _, found := range rule.Regex.FindAllStringSubmatchIndex(*diffObject.Diff, -1) {
+			if start, end := rule.secretSpan(found); start != end {
+				matches = append(matches, &Match{Reason: rule.Reason, Start: start, End: end})
+			}
+		}
+	}
+	return matches
+}
+
+// EntropyDetector finds secrets by breaking a diff into words and running an entropy check
+// on valid base64 and hex strings within each word.
+// Code taken from https://github.com/dxa4481/truffleHog.
+type EntropyDetector struct{}
+
+// Detect runs an entropy check on every valid base64 and hex string of a given diff.
+func (d *EntropyDetector) Detect(m *Middleware, diffObject *DiffObject) []*Match {
+	var matches []*Match
+	for _, word := range strings.Fields(*diffObject.Diff) {
+		b64strings := FindValidStrings(word, B64chars)
+		hexstrings := FindValidStrings(word, Hexchars)
+		matches = append(matches, findEntropyMatches(b64strings, *diffObject.Diff, b64Threshold)...)
+		matches = append(matches, findEntropyMatches(hexstrings, *diffObject.Diff, hexThreshold)...)
+	}
+	return matches
+}
+
+// findEntropyMatches checks for a given validString set whether the threshold is broken
+// and if it is, returns the first occurrence of the string within the diff.
+func findEntropyMatches(validStrings []string, diff string, threshold float64) []*Match {
+	var matches []*Match
+	for _, validString := range validStrings {
+		if EntropyCheck(validString, B64chars) > threshold {
+			start := strings.Index(diff, validString)
+			matches = append(matches, &Match{
+				Reason: EntropyReason,
+				Start:  start,
+				End:    start + len(validString),
+			})
+		}
+	}
+	return matches
+}
+
+// AnalyzeDiff runs every detector on a given diff and reports what they find.
+// Sources call AnalyzeDiff on each diff they provide.
+func AnalyzeDiff(m *Middleware, diffObject *DiffObject) {
+	reportMatches(m, detectDiff(m, diffObject), diffObject)
+}
+
+// detectDiff runs every detector on a given diff and returns what they find without reporting it.
+func detectDiff(m *Middleware, diffObject *DiffObject) []*Match {
+	var matches []*Match
+	for _, detector := range m.Detectors {
+		matches = append(matches, detector.Detect(m, diffObject)...)
+	}
+	return matches
+}
+
+// diffLines holds the lines of a diff along with the offset each of them starts at,
+// so the lines of the matches within the diff can be found without splitting it again.
+type diffLines struct {
+	diff   string
+	lines  []string
+	starts []int
+}
+
+// newDiffLines splits a given diff into its' lines.
+func newDiffLines(diff string) *diffLines {
+	lines := strings.Split(diff, "\n")
+	starts := make([]int, len(lines))
+	for index := 1; index < len(lines); index++ {
+		starts[index] = starts[index-1] + len(lines[index-1]) + 1
+	}
+	return &diffLines{diff: diff, lines: lines, starts: starts}
+}
+
+// lineAt returns the index of the line containing a given offset of the diff.
+func (d *diffLines) lineAt(offset int) int {
+	return sort.Search(len(d.starts), func(index int) bool { return d.starts[index] > offset }) - 1
+}
+
+// reportMatches reports the given matches of a diff, splitting the diff into lines only once.
+func reportMatches(m *Middleware, matches []*Match, diffObject *DiffObject) {
+	if len(matches) == 0 {
+		return
+	}
+	lines := newDiffLines(*diffObject.Diff)
+	for _, match := range matches {
+		reportMatch(m, match, diffObject, lines)
+	}
+}
+
+// reportMatch finds the context lines around a match within the given lines of its' diff and reports it as a finding.
+func reportMatch(m *Middleware, match *Match, diffObject *DiffObject, lines *diffLines) {
+	lineNum := lines.lineAt(match.Start)
+	endLineNum := lines.lineAt(match.End)
+
+	start, end := Max(0, lineNum-*m.Flags.Context), Min(len(lines.lines), endLineNum+*m.Flags.Context+1)
+	offset := lines.starts[start]
+	contextDiff := lines.diff[offset : lines.starts[end-1]+len(lines.lines[end-1])]
+	secret := []int{match.Start - offset, match.End - offset}
+	ReportFinding(m, match.Reason, secret, lineNum, diffObject, lines.lines[lineNum], contextDiff)
+}
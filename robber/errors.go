@@ -0,0 +1,91 @@
+package robber
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSkipped is returned when a repository is skipped, or only partially scanned, for exceeding a limit.
+var ErrSkipped = errors.New("Repository was skipped")
+
+// UsageError is returned when invalid CLI arguments were given, it holds the usage of yar.
+type UsageError struct {
+	Usage string
+}
+
+func (e *UsageError) Error() string {
+	return e.Usage
+}
+
+// ConfigError is returned when the config, or a file given along with it, is invalid.
+type ConfigError struct {
+	Msg string
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Err == nil {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s: %s", e.Msg, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError is returned when Github rejects the given access token.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("Github token is invalid: %s", e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// RateLimitError is returned when Github's rate limit is hit while listing the repositories
+// or members of a given user or organization, so only the ones listed before it were scanned.
+type RateLimitError struct {
+	Name string
+	Err  error
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("Hit Github rate limit while listing the repositories or members of %s, the rest of them were skipped: %s", e.Name, e.Err)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+// NotFoundError is returned when a given user, organization, commit or directory does not exist.
+type NotFoundError struct {
+	Name string
+	Err  error
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s does not exist", e.Name)
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// CorruptedRepoError is returned when a repository can't be read.
+type CorruptedRepoError struct {
+	Repo string
+	Err  error
+}
+
+func (e *CorruptedRepoError) Error() string {
+	return fmt.Sprintf("%s is corrupted please run yar --cleanup %s and try again: %s", e.Repo, e.Repo, e.Err)
+}
+
+func (e *CorruptedRepoError) Unwrap() error {
+	return e.Err
+}
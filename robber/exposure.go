@@ -0,0 +1,133 @@
+package robber
+
+import (
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+)
+
+// Exposure describes the window of time a unique secret was exposed within a repository,
+// from the commit that introduced it to the commit that removed it, if it was ever removed.
+type Exposure struct {
+	Secret           string
+	Reason           string
+	Files            map[string]bool
+	IntroducedCommit string
+	IntroducedDate   time.Time
+	RemovedCommit    string
+	RemovedDate      time.Time
+	AtHead           bool
+	// Reported is set once a finding of the secret has been logged
+	Reported bool
+}
+
+// Introduced checks whether the commit which introduced the secret was scanned.
+func (e *Exposure) Introduced() bool {
+	return e.IntroducedCommit != ""
+}
+
+// Removed checks whether the secret was removed after it was last introduced.
+func (e *Exposure) Removed() bool {
+	return e.RemovedCommit != ""
+}
+
+// Window returns how long the secret was exposed. Secrets which are still present
+// at HEAD have been exposed until now.
+func (e *Exposure) Window() time.Duration {
+	if !e.Introduced() {
+		return 0
+	}
+	if e.Removed() && !e.AtHead {
+		return e.RemovedDate.Sub(e.IntroducedDate)
+	}
+	return time.Since(e.IntroducedDate)
+}
+
+// TrackExposure updates the exposure of the secret of a given finding within a repo and returns it.
+// Findings must be tracked in the order of commit history, from oldest to newest.
+func (m *Middleware) TrackExposure(reponame string, secret string, finding *Finding, date time.Time) *Exposure {
+	m.Lock()
+	defer m.Unlock()
+	if m.Exposures[reponame] == nil {
+		m.Exposures[reponame] = make(map[string]*Exposure)
+	}
+	exposure, ok := m.Exposures[reponame][secret]
+	if !ok {
+		exposure = &Exposure{
+			Secret: secret,
+			Reason: finding.Reason,
+			Files:  make(map[string]bool),
+		}
+		m.Exposures[reponame][secret] = exposure
+	}
+
+	exposure.Files[finding.Filepath] = true
+	if finding.Added {
+		if !exposure.Introduced() {
+			exposure.IntroducedCommit = finding.CommitHash
+			exposure.IntroducedDate = date
+		}
+		// The secret was reintroduced after being removed
+		exposure.RemovedCommit = ""
+		exposure.RemovedDate = time.Time{}
+	} else {
+		exposure.RemovedCommit = finding.CommitHash
+		exposure.RemovedDate = date
+	}
+	return exposure
+}
+
+// CheckExposures checks for every reported secret within a given repo whether it still exists
+// in any of the files it was found in at HEAD, reading each of those files once, and reports
+// the exposure of each secret to the sinks.
+func CheckExposures(m *Middleware, repo *git.Repository, reponame string) {
+	m.Lock()
+	exposures := make(map[string]*Exposure)
+	for secret, exposure := range m.Exposures[reponame] {
+		if exposure.Reported {
+			exposures[secret] = exposure
+		}
+	}
+	m.Unlock()
+	if len(exposures) == 0 {
+		return
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return
+	}
+
+	// Each file is only read once, however many secrets were found in it
+	files := make(map[string][]*Exposure)
+	for _, exposure := range exposures {
+		for filepath := range exposure.Files {
+			files[filepath] = append(files[filepath], exposure)
+		}
+	}
+	for filepath, fileExposures := range files {
+		file, err := tree.File(filepath)
+		if err != nil {
+			continue
+		}
+		content, err := file.Contents()
+		if err != nil {
+			continue
+		}
+		for _, exposure := range fileExposures {
+			if !exposure.AtHead && strings.Contains(content, exposure.Secret) {
+				exposure.AtHead = true
+			}
+		}
+	}
+	m.ReportExposures(reponame, exposures)
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/akamensky/argparse"
 )
@@ -27,7 +29,12 @@ type Flags struct {
 	Org            *string
 	User           *string
 	Repo           *string
+	Path           *string
+	StdinDiff      *bool
 	Save           *string
+	Format         *string
+	Summary        *string
+	Baseline       *string
 	CleanUp        *string
 	Noise          *string
 	Config         *os.File
@@ -37,14 +44,38 @@ type Flags struct {
 	Forks          *bool
 	NoBare         *bool
 	NoCache        *bool
+	NoUpdate       *bool
 	IncludeMembers *bool
 	SkipDuplicates *bool
+	Incremental    *bool
+	DedupRepos     *bool
+	Gitignore      *bool
+	UpdateBaseline *bool
+	AllRefs        *bool
+	Branches       *[]string
+	Tags           *[]string
+	FailOn         *[]string
+	SinceCommit    *string
+	UntilCommit    *string
+	SinceDate      *string
+	Range          *string
+	RepoTimeout    *string
+	MaxRepoSize    *string
 	Context        *int
 	CommitDepth    *int
+	Workers        *int
+	CommitWorkers  *int
 
 	SavePresent    bool
 	CleanUpPresent bool
+	Hook           string
+	HookArgs       []string
 	NoiseLevel     Bound
+	Since          time.Time
+	Timeout        time.Duration
+	MaxSize        int64
+	FailOnNoise    []Bound
+	FailOnRules    map[string]bool
 }
 
 func validateInt(argname string, arg string, Bound Bound) (int, error) {
@@ -99,6 +130,58 @@ func parseNoiseLevel(noise string) (Bound, error) {
 	}
 }
 
+func parseDate(date string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Date must be in the form YYYY-MM-DD or RFC3339")
+}
+
+var sizeUnits = map[string]int64{"": 1, "B": 1, "KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "TB": 1 << 40}
+
+func parseSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	unitStart := strings.IndexFunc(size, func(r rune) bool { return r < '0' || r > '9' })
+	if unitStart == -1 {
+		unitStart = len(size)
+	}
+	num, err := strconv.ParseInt(size[:unitStart], 10, 64)
+	unit, ok := sizeUnits[strings.TrimSpace(size[unitStart:])]
+	if err != nil || !ok || num <= 0 {
+		return 0, errors.New("Size must be a positive number with an optional unit of B, KB, MB, GB or TB, i.e. 500MB")
+	}
+	return num * unit, nil
+}
+
+func parseRange(commitRange string) (string, string, error) {
+	bounds := strings.Split(commitRange, "..")
+	if len(bounds) != 2 || bounds[0] == "" || bounds[1] == "" {
+		return "", "", errors.New("Range must be in the form base..head")
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// DefaultConfigPath returns the path of the config file which is used if none is given.
+func DefaultConfigPath() string {
+	return filepath.Join(GetGoPath(), "src", "github.com", "nielsing", "yar", "config", "yarconfig.json")
+}
+
+// parseFailOn splits the values of the --fail-on flag into noise levels and reasons of rules.
+func parseFailOn(values []string) ([]Bound, map[string]bool) {
+	var levels []Bound
+	reasons := make(map[string]bool)
+	for _, value := range values {
+		if level, err := parseNoiseLevel(value); err == nil {
+			levels = append(levels, level)
+		} else {
+			reasons[value] = true
+		}
+	}
+	return levels, reasons
+}
+
 func validErr(err error) bool {
 	return err.Error() != "not enough arguments for -s|--save" && err.Error() != "not enough arguments for --cleanup"
 }
@@ -113,8 +196,16 @@ func flagPresent(shortHand string, name string) bool {
 }
 
 // ParseFlags parses CLI arguments and returns them.
-func ParseFlags() *Flags {
+// A UsageError holding the usage of yar is returned if the arguments are invalid.
+func ParseFlags() (*Flags, error) {
 	parser := argparse.NewParser("yar", "Sail ye seas of git for booty is to be found")
+	args, hook := os.Args, ""
+	if len(args) > 1 && args[1] == "hook" {
+		if len(args) < 3 || !validHook(args[2]) {
+			return nil, &UsageError{Usage: hookUsage}
+		}
+		args, hook = append([]string{args[0]}, args[3:]...), args[2]
+	}
 	flags := &Flags{
 		Org: parser.String("o", "org", &argparse.Options{
 			Required: false,
@@ -131,6 +222,17 @@ func ParseFlags() *Flags {
 			Help:     "Repository to plunder",
 		}),
 
+		Path: parser.String("", "path", &argparse.Options{
+			Required: false,
+			Help:     "Directory to plunder as is, without its' git history",
+		}),
+
+		StdinDiff: parser.Flag("", "stdin-diff", &argparse.Options{
+			Required: false,
+			Help:     "Plunder unified diff text given on stdin, i.e. the output of git diff or a patch file",
+			Default:  false,
+		}),
+
 		Context: parser.Int("c", "context", &argparse.Options{
 			Required: false,
 			Help:     "Show N number of lines for context",
@@ -163,7 +265,7 @@ func ParseFlags() *Flags {
 		Noise: parser.String("n", "noise", &argparse.Options{
 			Required: false,
 			Help:     "Specify the range of the noise for rules. Can be specified as up to (and including) a certain value (-4), from a certain value (5-), between two values (3-5), just a single value (4) or the whole range (-)",
-			Default:  "-3",
+			Default:  defaultNoise,
 			Validate: func(args []string) error {
 				_, err := parseNoiseLevel(args[0])
 				return err
@@ -173,7 +275,7 @@ func ParseFlags() *Flags {
 		CommitDepth: parser.Int("d", "depth", &argparse.Options{
 			Required: false,
 			Help:     "Specify the depth limit of commits fetched when cloning",
-			Default:  10000,
+			Default:  defaultDepth,
 			Validate: func(args []string) error {
 				_, err := validateInt("Depth", args[0], Bound{0, maxInt})
 				return err
@@ -183,7 +285,7 @@ func ParseFlags() *Flags {
 		Config: parser.File("C", "config", os.O_RDONLY, 0600, &argparse.Options{
 			Required: false,
 			Help:     "JSON file containing yar config",
-			Default:  filepath.Join(GetGoPath(), "src", "github.com", "nielsing", "yar", "config", "yarconfig.json"),
+			Default:  DefaultConfigPath(),
 			Validate: func(args []string) error {
 				filename := args[0]
 				_, err := os.Stat(filename)
@@ -211,6 +313,12 @@ func ParseFlags() *Flags {
 			Default:  false,
 		}),
 
+		NoUpdate: parser.Flag("", "no-update", &argparse.Options{
+			Required: false,
+			Help:     "Don't fetch new commits for repositories loaded from cache",
+			Default:  false,
+		}),
+
 		// Overrides context flag
 		NoContext: parser.Flag("", "no-context", &argparse.Options{
 			Required: false,
@@ -230,6 +338,120 @@ func ParseFlags() *Flags {
 			Default:  false,
 		}),
 
+		Incremental: parser.Flag("", "incremental", &argparse.Options{
+			Required: false,
+			Help:     "Only scan commits which haven't been scanned with the same rules in a previous run",
+			Default:  false,
+		}),
+
+		DedupRepos: parser.Flag("", "dedup-across-repos", &argparse.Options{
+			Required: false,
+			Help:     "Only analyze each diff of a file once across all repositories instead of once per repository, i.e. for forks",
+			Default:  false,
+		}),
+
+		Gitignore: parser.Flag("", "gitignore", &argparse.Options{
+			Required: false,
+			Help:     "Skip files ignored by .gitignore files when plunderin' a directory",
+			Default:  false,
+		}),
+
+		AllRefs: parser.Flag("", "all-refs", &argparse.Options{
+			Required: false,
+			Help:     "Scan the history of every branch, tag and reference instead of just HEAD",
+			Default:  false,
+		}),
+
+		Branches: parser.List("", "branch", &argparse.Options{
+			Required: false,
+			Help:     "Scan the history of the given branch instead of HEAD. Can be given multiple times",
+		}),
+
+		Tags: parser.List("", "tag", &argparse.Options{
+			Required: false,
+			Help:     "Scan the history of the given tag instead of HEAD. Can be given multiple times",
+		}),
+
+		SinceCommit: parser.String("", "since-commit", &argparse.Options{
+			Required: false,
+			Help:     "Only scan commits which are not reachable from the given commit",
+			Default:  "",
+		}),
+
+		UntilCommit: parser.String("", "until-commit", &argparse.Options{
+			Required: false,
+			Help:     "Start scanning from the given commit instead of HEAD",
+			Default:  "",
+		}),
+
+		SinceDate: parser.String("", "since-date", &argparse.Options{
+			Required: false,
+			Help:     "Only scan commits committed on or after the given date (YYYY-MM-DD or RFC3339)",
+			Default:  "",
+			Validate: func(args []string) error {
+				_, err := parseDate(args[0])
+				return err
+			},
+		}),
+
+		// Overrides since-commit and until-commit flags
+		Range: parser.String("", "range", &argparse.Options{
+			Required: false,
+			Help:     "Only scan the commits in the given base..head range. Overrides since-commit and until-commit flags",
+			Default:  "",
+			Validate: func(args []string) error {
+				_, _, err := parseRange(args[0])
+				return err
+			},
+		}),
+
+		Workers: parser.Int("", "workers", &argparse.Options{
+			Required: false,
+			Help:     "Number of repositories scanned at the same time. 0 scans one repository per CPU",
+			Default:  0,
+			Validate: func(args []string) error {
+				_, err := validateInt("Workers", args[0], Bound{0, maxInt})
+				return err
+			},
+		}),
+
+		CommitWorkers: parser.Int("", "commit-workers", &argparse.Options{
+			Required: false,
+			Help:     "Number of commits of a repository analyzed at the same time. 0 analyzes one commit per CPU",
+			Default:  0,
+			Validate: func(args []string) error {
+				_, err := validateInt("Commit workers", args[0], Bound{0, maxInt})
+				return err
+			},
+		}),
+
+		RepoTimeout: parser.String("", "repo-timeout", &argparse.Options{
+			Required: false,
+			Help:     "Stop scanning a repository once it has taken longer than the given duration, i.e. 30m or 1h30m, and report it as skipped",
+			Default:  "",
+			Validate: func(args []string) error {
+				if timeout, err := time.ParseDuration(args[0]); err != nil || timeout <= 0 {
+					return errors.New("Repo timeout must be a positive duration, i.e. 30m or 1h30m")
+				}
+				return nil
+			},
+		}),
+
+		MaxRepoSize: parser.String("", "max-repo-size", &argparse.Options{
+			Required: false,
+			Help:     "Skip repositories larger than the given size, i.e. 500MB or 2GB, and report them as skipped",
+			Default:  "",
+			Validate: func(args []string) error {
+				_, err := parseSize(args[0])
+				return err
+			},
+		}),
+
+		FailOn: parser.List("", "fail-on", &argparse.Options{
+			Required: false,
+			Help:     "Only exit with 1 for findings of the given noise levels, in the same form as the noise flag, or of the rule with the given reason. Can be given multiple times",
+		}),
+
 		// If cleanup is set, yar will ignore all other flags and only perform cleanup
 		CleanUp: parser.String("", "cleanup", &argparse.Options{
 			Required: false,
@@ -243,27 +465,70 @@ func ParseFlags() *Flags {
 			Default:  "findings.json",
 		}),
 
+		Format: parser.Selector("", "format", []string{"json", "jsonl", "sarif"}, &argparse.Options{
+			Required: false,
+			Help:     "Format of the saved findings, either yar's own JSON format, JSON Lines written as findings are found or SARIF 2.1.0",
+			Default:  "json",
+		}),
+
+		Summary: parser.String("", "summary", &argparse.Options{
+			Required: false,
+			Help:     "Save a summary of the scan, i.e. the number of repos scanned and findings per rule, to the given JSON file",
+			Default:  "",
+		}),
+
+		Baseline: parser.String("", "baseline", &argparse.Options{
+			Required: false,
+			Help:     "File of previously saved findings which are suppressed when found again",
+			Default:  "",
+		}),
+
+		UpdateBaseline: parser.Flag("", "update-baseline", &argparse.Options{
+			Required: false,
+			Help:     "Add all new findings to the baseline file",
+			Default:  false,
+		}),
+
 		// These are hack flags that are proof of bad design on my hand :/
 		SavePresent:    flagPresent("-s", "--save"),
 		CleanUpPresent: flagPresent("", "--cleanup"),
+		Hook:           hook,
+		HookArgs:       args[1:],
 	}
 
-	if err := parser.Parse(os.Args); err != nil && validErr(err) {
-		fmt.Print(parser.Usage(err))
-		os.Exit(1)
+	if err := parser.Parse(args); err != nil && validErr(err) {
+		return nil, &UsageError{Usage: parser.Usage(err)}
+	}
+	if err := validateFlags(flags, parser); err != nil {
+		return nil, err
 	}
-	validateFlags(flags, parser)
-	return flags
+	return flags, nil
 }
 
-func validateFlags(flags *Flags, parser *argparse.Parser) {
-	if *flags.User == "" && *flags.Repo == "" && *flags.Org == "" && !flags.CleanUpPresent {
-		fmt.Print(parser.Usage("Must give atleast one of org/user/repo"))
-		os.Exit(1)
+func validateFlags(flags *Flags, parser *argparse.Parser) error {
+	if *flags.User == "" && *flags.Repo == "" && *flags.Org == "" && *flags.Path == "" && !*flags.StdinDiff && !flags.CleanUpPresent && flags.Hook == "" {
+		return &UsageError{Usage: parser.Usage("Must give atleast one of org/user/repo/path/stdin-diff")}
+	}
+	if *flags.UpdateBaseline && *flags.Baseline == "" {
+		return &UsageError{Usage: parser.Usage("--update-baseline requires a --baseline file")}
 	}
 	if *flags.Save == "" {
 		*flags.Save = "findings.json"
 	}
 	level, _ := parseNoiseLevel(*flags.Noise)
 	flags.NoiseLevel = level
+	if *flags.Range != "" {
+		*flags.SinceCommit, *flags.UntilCommit, _ = parseRange(*flags.Range)
+	}
+	if *flags.SinceDate != "" {
+		flags.Since, _ = parseDate(*flags.SinceDate)
+	}
+	flags.FailOnNoise, flags.FailOnRules = parseFailOn(*flags.FailOn)
+	if *flags.RepoTimeout != "" {
+		flags.Timeout, _ = time.ParseDuration(*flags.RepoTimeout)
+	}
+	if *flags.MaxRepoSize != "" {
+		flags.MaxSize, _ = parseSize(*flags.MaxRepoSize)
+	}
+	return nil
 }
@@ -1,113 +1,504 @@
 package robber
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/sergi/go-diff/diffmatchpatch"
 	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/format/diff"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	diffutil "gopkg.in/src-d/go-git.v4/utils/diff"
 )
 
+// Hunk holds a part of a file diff which was either added or removed by a commit.
+// StartLine is the line number of the first line of the hunk within the new version
+// of the file if the hunk was added, otherwise within the old version of the file.
+type Hunk struct {
+	Content   string
+	StartLine int
+	Added     bool
+}
+
 // DiffObject holds everything that is needed to analyze a diff.
 type DiffObject struct {
-	Commit   *object.Commit
-	Diff     *string
-	Reponame *string
-	Filepath *string
+	Commit    *object.Commit
+	Diff      *string
+	Reponame  *string
+	Filepath  *string
+	Refs      []string
+	StartLine int
+	Added     bool
 }
 
 // NewDiffObject returns a new DiffObject.
-func NewDiffObject(commit *object.Commit, diff, reponame, filepath *string) *DiffObject {
+func NewDiffObject(commit *object.Commit, hunk *Hunk, reponame, filepath *string, refs []string) *DiffObject {
 	return &DiffObject{
-		Commit:   commit,
-		Diff:     diff,
-		Reponame: reponame,
-		Filepath: filepath,
+		Commit:    commit,
+		Diff:      &hunk.Content,
+		Reponame:  reponame,
+		Filepath:  filepath,
+		Refs:      refs,
+		StartLine: hunk.StartLine,
+		Added:     hunk.Added,
+	}
+}
+
+// getAuth returns authentication for github if an AccessToken was given.
+func getAuth(m *Middleware) transport.AuthMethod {
+	if m.AccessToken == "" {
+		return nil
+	}
+	return &http.BasicAuth{
+		Username: "NotEmpty", // https://godoc.org/gopkg.in/src-d/go-git.v4#PlainClone
+		Password: m.AccessToken,
 	}
 }
 
 // getCloneOptions returns either an authenticated clone of a repo or an
 // anonymous clone of a repo based on whether an AccessToken was given or not.
 func getCloneOptions(m *Middleware, url string) *git.CloneOptions {
-	if m.AccessToken != "" {
-		return &git.CloneOptions{
-			URL:   url,
-			Depth: *m.Flags.CommitDepth + 1, // There is an off by one error in Depth field.
-			Auth: &http.BasicAuth{
-				Username: "NotEmpty", // https://godoc.org/gopkg.in/src-d/go-git.v4#PlainClone
-				Password: m.AccessToken,
-			},
-		}
-	}
 	return &git.CloneOptions{
 		URL:   url,
 		Depth: *m.Flags.CommitDepth + 1, // There is an off by one error in Depth field.
+		Auth:  getAuth(m),
 	}
 }
 
 // cloneRepo creates a temp directory in the OS's temp directory
 // and clones the given URL into it.
-func cloneRepo(m *Middleware, url string, cloneFolder string) (*git.Repository, error) {
+func cloneRepo(ctx context.Context, m *Middleware, url string, cloneFolder string) (*git.Repository, error) {
 	opt := getCloneOptions(m, url)
-	repo, err := git.PlainClone(cloneFolder, !*m.Flags.NoBare, opt)
+	repo, err := git.PlainCloneContext(ctx, cloneFolder, !*m.Flags.NoBare, opt)
 	if err != nil {
 		return nil, err
 	}
 	return repo, nil
 }
 
+// isCached checks whether a given directory is within yar's cache folder.
+func isCached(dir string) bool {
+	return strings.HasPrefix(dir, filepath.Join(os.TempDir(), "yar")+string(filepath.Separator))
+}
+
+// getRepoSize returns the size of a repository on disk.
+func getRepoSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// updateRepo fetches new commits and tags of a cached repository and moves
+// its' local branches to the fetched commits of their remote counterparts.
+func updateRepo(ctx context.Context, m *Middleware, repo *git.Repository) error {
+	err := repo.FetchContext(ctx, &git.FetchOptions{
+		Depth: *m.Flags.CommitDepth + 1, // There is an off by one error in Depth field.
+		Auth:  getAuth(m),
+		Tags:  git.AllTags,
+		Force: true,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	branches, err := repo.Branches()
+	if err != nil {
+		return err
+	}
+	return branches.ForEach(func(branch *plumbing.Reference) error {
+		remoteName := plumbing.NewRemoteReferenceName("origin", branch.Name().Short())
+		remote, err := repo.Reference(remoteName, true)
+		if err != nil {
+			return nil
+		}
+		return repo.Storer.SetReference(plumbing.NewHashReference(branch.Name(), remote.Hash()))
+	})
+}
+
+// openCachedRepo opens a repository which already exists on disk. Repositories within
+// yar's cache folder are brought up to date unless the --no-update flag was given.
+func openCachedRepo(ctx context.Context, m *Middleware, dir string) (*git.Repository, error) {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+	if isCached(dir) && !*m.Flags.NoUpdate {
+		if err := updateRepo(ctx, m, repo); err != nil && ctx.Err() == nil {
+			m.Logger.LogWarn("Unable to update %s, using cached version: %s\n", dir, err)
+		}
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return repo, err
+	}
+	if _, err := repo.CommitObject(head.Hash()); err != nil {
+		return repo, err
+	}
+	return repo, nil
+}
+
+// getRemoteURL returns the URL a repository was cloned from, if it can be found.
+func getRemoteURL(repo *git.Repository) string {
+	if repo == nil {
+		return ""
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return ""
+	}
+	return remote.Config().URLs[0]
+}
+
 // OpenRepo opens a repository found at the given path.
 // If the path points to a nonexistant repository it assumes that an URL
-// was given and tries to clone it instead.
-func OpenRepo(m *Middleware, path string) (*git.Repository, error) {
+// was given and tries to clone it instead. Cached repositories which turn
+// out to be corrupted are recloned.
+func OpenRepo(ctx context.Context, m *Middleware, path string) (*git.Repository, error) {
 	dir, exists := GetDir(path)
+	url := path
 	if !*m.Flags.NoCache && !*m.Flags.NoBare && exists {
-		repo, err := git.PlainOpen(dir)
-		if err != nil {
+		repo, err := openCachedRepo(ctx, m, dir)
+		if err == nil {
+			return repo, nil
+		}
+		if remoteURL := getRemoteURL(repo); remoteURL != "" {
+			url = remoteURL
+		}
+		if !isCached(dir) || url == dir {
 			return nil, err
 		}
-		return repo, nil
+		m.Logger.LogWarn("%s is corrupted, recloning it: %s\n", dir, err)
 	}
 
-	if *m.Flags.NoBare || *m.Flags.NoCache {
+	if *m.Flags.NoBare || *m.Flags.NoCache || exists {
 		os.RemoveAll(dir)
 	}
-	repo, err := cloneRepo(m, path, dir)
+	repo, err := cloneRepo(ctx, m, url, dir)
 	if err != nil {
 		return nil, err
 	}
 	return repo, nil
 }
 
-// GetCommits simply traverses a given repository, gathering all commits
-// and then returns a list of them.
-func GetCommits(m *Middleware, repo *git.Repository, reponame string) ([]*object.Commit, error) {
+// CommitRefs maps the hash of a commit to the names of the references it is reachable from.
+type CommitRefs map[plumbing.Hash][]string
+
+// resolveCommit returns the commit a given hash points to, peeling annotated tags.
+func resolveCommit(repo *git.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	if tag, err := repo.TagObject(hash); err == nil {
+		return tag.Commit()
+	}
+	return repo.CommitObject(hash)
+}
+
+// resolveRevision resolves a given revision, such as a commit hash or a branch name,
+// to the hash of the commit it points to.
+func resolveRevision(repo *git.Repository, reponame string, rev string) (plumbing.Hash, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return plumbing.ZeroHash, &NotFoundError{Name: fmt.Sprintf("Commit %s in %s", rev, reponame), Err: err}
+	}
+	return *hash, nil
+}
+
+// getExcludedCommits returns all commits which don't need to be scanned, that is every
+// commit reachable from the commit given with the --since-commit flag and every commit
+// reachable from a commit that was scanned in a previous run according to the scan state.
+func getExcludedCommits(m *Middleware, repo *git.Repository, reponame string, state *ScanState) (map[plumbing.Hash]bool, error) {
+	var tips []plumbing.Hash
+	if *m.Flags.SinceCommit != "" {
+		since, err := resolveRevision(repo, reponame, *m.Flags.SinceCommit)
+		if err != nil {
+			return nil, err
+		}
+		tips = append(tips, since)
+	}
+	for _, hash := range state.Refs {
+		// The commit may no longer exist if the repo was cleaned up or history was rewritten.
+		if commit, err := repo.CommitObject(plumbing.NewHash(hash)); err == nil {
+			tips = append(tips, commit.Hash)
+		}
+	}
+
+	return reachableCommits(repo, tips)
+}
+
+// reachableCommits returns every commit reachable from the given commits.
+func reachableCommits(repo *git.Repository, tips []plumbing.Hash) (map[plumbing.Hash]bool, error) {
+	reachable := make(map[plumbing.Hash]bool)
+	for _, tip := range tips {
+		if reachable[tip] {
+			continue
+		}
+		commitIter, err := repo.Log(&git.LogOptions{From: tip})
+		if err != nil {
+			return nil, err
+		}
+		commitIter.ForEach(func(c *object.Commit) error {
+			reachable[c.Hash] = true
+			return nil
+		})
+	}
+	return reachable, nil
+}
+
+// PendingCommit is a commit waiting to be analyzed, along with the names of the selected references containing it.
+type PendingCommit struct {
+	Hash plumbing.Hash
+	Refs []string
+}
+
+// GetPushedCommits streams the commits of the given ref updates which aren't reachable from any reference
+// of the repository yet, from the oldest to the newest, along with the names of the pushed references
+// containing each commit. Deleted references and references to trees or blobs are ignored.
+// History is walked as described for GetCommits.
+func GetPushedCommits(ctx context.Context, repo *git.Repository, reponame string, updates []*RefUpdate) (<-chan *PendingCommit, <-chan error, error) {
+	var tips []plumbing.Hash
+	refIter, err := repo.References()
+	if err != nil {
+		return nil, nil, err
+	}
+	refIter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		if commit, err := resolveCommit(repo, ref.Hash()); err == nil {
+			tips = append(tips, commit.Hash)
+		}
+		return nil
+	})
+	known, err := reachableCommits(repo, tips)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var pushed []*object.Commit
+	var names []string
+	for _, update := range updates {
+		if update.New == plumbing.ZeroHash {
+			continue
+		}
+		from, err := resolveCommit(repo, update.New)
+		if err != nil {
+			continue
+		}
+		pushed = append(pushed, from)
+		names = append(names, plumbing.ReferenceName(update.Ref).Short())
+	}
+	// Known commits are pruned along with their history
+	walk := func(tips ...*object.Commit) *historyIter {
+		return newHistoryIter(ctx, repo, tips, known, time.Time{}, -1)
+	}
+	refsOf := getRefsOf(pushed, names, walk)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	commits, errc := streamCommits(ctx, walk(pushed...), refsOf, func(r interface{}) error {
+		return fmt.Errorf("Unable to walk the history of %s: %v", reponame, r)
+	})
+	return commits, errc, nil
+}
+
+// getRefsOf returns a function which gives the names of the given references containing a commit,
+// given the tips of the references and a function walking history. Which references contain a
+// commit is only known once the histories of all of them have been walked, so with more than one
+// reference they're walked up front. Each commit can only be looked up once.
+func getRefsOf(tips []*object.Commit, names []string, walk func(...*object.Commit) *historyIter) func(plumbing.Hash) []string {
+	if len(names) <= 1 {
+		return func(plumbing.Hash) []string { return names }
+	}
+	commitRefs := make(CommitRefs)
+	for index, tip := range tips {
+		history := walk(tip)
+		for hash, ok := history.Next(); ok; hash, ok = history.Next() {
+			commitRefs[hash] = append(commitRefs[hash], names[index])
+		}
+	}
+	return func(hash plumbing.Hash) []string {
+		refs := commitRefs[hash]
+		delete(commitRefs, hash)
+		return refs
+	}
+}
+
+// streamCommits sends the commits a given historyIter yields on the returned channel along with the
+// references containing them, as given by refsOf, and closes it once history has been walked.
+// The walk runs on a go routine of its' own, and the returned error channel receives the error which
+// ended it, if any, before the commit channel is closed. A panic while walking is turned into an error
+// with the given function.
+func streamCommits(ctx context.Context, history *historyIter, refsOf func(plumbing.Hash) []string,
+	recovered func(interface{}) error) (<-chan *PendingCommit, <-chan error) {
+	commits := make(chan *PendingCommit)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(commits)
+		defer func() {
+			if r := recover(); r != nil {
+				errc <- recovered(r)
+			}
+		}()
+		for hash, ok := history.Next(); ok; hash, ok = history.Next() {
+			select {
+			case commits <- &PendingCommit{Hash: hash, Refs: refsOf(hash)}:
+			case <-ctx.Done():
+			}
+		}
+		errc <- ctx.Err()
+	}()
+	return commits, errc
+}
+
+// getRefs returns the references whose histories should be walked based on
+// the --until-commit, --all-refs, --branch and --tag flags. A warning is logged for selected branches
+// and tags which don't exist in the repository, and a NotFoundError is returned if none of them exist.
+// If no selection was given HEAD is returned.
+func getRefs(m *Middleware, repo *git.Repository, reponame string) ([]*plumbing.Reference, error) {
+	if *m.Flags.UntilCommit != "" {
+		until, err := resolveRevision(repo, reponame, *m.Flags.UntilCommit)
+		if err != nil {
+			return nil, err
+		}
+		return []*plumbing.Reference{
+			plumbing.NewHashReference(plumbing.ReferenceName(*m.Flags.UntilCommit), until),
+		}, nil
+	}
+
+	var refs []*plumbing.Reference
+	var missing []string
+	if *m.Flags.AllRefs {
+		iter, err := repo.References()
+		if err != nil {
+			return nil, err
+		}
+		err = iter.ForEach(func(ref *plumbing.Reference) error {
+			if ref.Type() == plumbing.HashReference {
+				refs = append(refs, ref)
+			}
+			return nil
+		})
+		return refs, err
+	}
+
+	for _, branch := range *m.Flags.Branches {
+		names := []plumbing.ReferenceName{
+			plumbing.NewBranchReferenceName(branch),
+			plumbing.NewRemoteReferenceName("origin", branch),
+		}
+		found := false
+		for _, name := range names {
+			if ref, err := repo.Reference(name, true); err == nil {
+				refs = append(refs, ref)
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, branch)
+		}
+	}
+	for _, tag := range *m.Flags.Tags {
+		if ref, err := repo.Reference(plumbing.NewTagReferenceName(tag), true); err == nil {
+			refs = append(refs, ref)
+		} else {
+			missing = append(missing, tag)
+		}
+	}
+	if len(*m.Flags.Branches) != 0 || len(*m.Flags.Tags) != 0 {
+		if len(refs) == 0 {
+			name := fmt.Sprintf("Branch or tag %s of %s", strings.Join(missing, ", "), reponame)
+			return nil, &NotFoundError{Name: name, Err: plumbing.ErrReferenceNotFound}
+		}
+		for _, name := range missing {
+			m.Logger.LogWarn("Branch or tag %s of %s does not exist\n", name, reponame)
+		}
+		return refs, nil
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	return []*plumbing.Reference{head}, nil
+}
+
+// GetCommits walks the history of every selected reference of a given repository and streams
+// the commits to analyze from the oldest to the newest, so they can be analyzed while history is
+// still being walked. Commits shared between references are only sent once, along with the names
+// of the references containing them when references were selected.
+// Commits excluded by the --since-commit and --since-date flags or by the scan state are skipped,
+// commits more than --depth commits away from their reference are left out and the scan state is
+// updated with the commit each reference points to.
+//
+// History is walked on the given repository by a go routine of its' own until the commit channel
+// is closed, and since go-git isn't safe for concurrent use the repository can't be used by anything
+// else meanwhile. The error channel receives the error which ended the walk, if any, before that.
+func GetCommits(ctx context.Context, m *Middleware, repo *git.Repository, reponame string, state *ScanState) (commits <-chan *PendingCommit, errc <-chan error, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			m.Logger.LogFail("%s is corrupted please run yar --cleanup %s and try again\n",
-				reponame[9:], reponame[9:])
+			commits, errc, err = nil, nil, corruptedRepo(reponame, r)
 		}
 	}()
 
-	var commits []*object.Commit
-	ref, err := repo.Head()
-	commitIter, err := repo.Log(&git.LogOptions{From: ref.Hash(), Order: git.LogOrderCommitterTime})
+	refs, err := getRefs(m, repo, reponame)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	excluded, err := getExcludedCommits(m, repo, reponame, state)
+	if err != nil {
+		return nil, nil, err
 	}
+	trackRefs := *m.Flags.UntilCommit == "" &&
+		(*m.Flags.AllRefs || len(*m.Flags.Branches) != 0 || len(*m.Flags.Tags) != 0)
 
-	count := 0
-	commitIter.ForEach(func(c *object.Commit) error {
-		if count == *m.Flags.CommitDepth {
-			return nil
+	var tips []*object.Commit
+	var names []string
+	for _, ref := range refs {
+		from, err := resolveCommit(repo, ref.Hash())
+		if err != nil {
+			// References may point to trees or blobs, which have no history.
+			continue
 		}
-		commits = append(commits, c)
-		count++
-		return nil
+		state.Refs[ref.Name().String()] = from.Hash.String()
+		tips = append(tips, from)
+		if trackRefs {
+			names = append(names, ref.Name().Short())
+		}
+	}
+
+	walk := func(tips ...*object.Commit) *historyIter {
+		return newHistoryIter(ctx, repo, tips, excluded, m.Flags.Since, *m.Flags.CommitDepth)
+	}
+	refsOf := getRefsOf(tips, names, walk)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	commits, errc = streamCommits(ctx, walk(tips...), refsOf, func(r interface{}) error {
+		return corruptedRepo(reponame, r)
 	})
-	return commits, nil
+	return commits, errc, nil
+}
+
+// corruptedRepo returns a CorruptedRepoError for a given repository which panicked while being read.
+func corruptedRepo(reponame string, r interface{}) error {
+	dir, _ := GetDir(reponame)
+	cacheDir, _ := filepath.Rel(filepath.Join(os.TempDir(), "yar"), dir)
+	return &CorruptedRepoError{Repo: cacheDir, Err: fmt.Errorf("%v", r)}
 }
 
 func getParentTree(commit *object.Commit) (*object.Tree, error) {
@@ -142,16 +533,16 @@ func GetCommitChanges(commit *object.Commit) (object.Changes, error) {
 		return nil, err
 	}
 
-	changes, err := object.DiffTree(commitTree, parentTree)
+	changes, err := object.DiffTree(parentTree, commitTree)
 	if err != nil {
 		return nil, err
 	}
 	return changes, nil
 }
 
-// GetDiffs gets all diffs which are either of type addage or removal
-// for a change in a commit.
-func GetDiffs(m *Middleware, change *object.Change, reponame string) ([]string, string, error) {
+// GetDiffs gets all hunks which are either of type addage or removal
+// for a change in a commit, along with their positions within the file.
+func GetDiffs(m *Middleware, change *object.Change, reponame string) ([]*Hunk, string, error) {
 	// This is done to handle the following inevitable error https://github.com/sergi/go-diff/issues/89
 	// If you run into this error a bunch of times then please take a look at the issue and see if you can
 	// contribute a fix :).
@@ -166,7 +557,7 @@ func GetDiffs(m *Middleware, change *object.Change, reponame string) ([]string,
 		return nil, "", err
 	}
 
-	var diffs []string
+	var hunks []*Hunk
 	var filename string
 	for _, file := range patch.FilePatches() {
 		if file.IsBinary() {
@@ -176,25 +567,64 @@ func GetDiffs(m *Middleware, change *object.Change, reponame string) ([]string,
 		if blacklistedFile(m, filename) {
 			continue
 		}
+		// Line numbers of the old and new version of the file
+		oldLine, newLine := 1, 1
 		for _, chunk := range file.Chunks() {
+			content := chunk.Content()
+			numOfLines := countLines(content)
 			// Only look at diffs that add/remove something
-			if chunk.Type() == 0 {
+			switch chunk.Type() {
+			case diff.Equal:
+				oldLine += numOfLines
+				newLine += numOfLines
 				continue
+			case diff.Add:
+				hunks = append(hunks, newHunk(content, newLine, true))
+				newLine += numOfLines
+			case diff.Delete:
+				hunks = append(hunks, newHunk(content, oldLine, false))
+				oldLine += numOfLines
 			}
-			diff := strings.Trim(chunk.Content(), " \n")
-			diffs = append(diffs, diff)
 		}
 	}
-	return diffs, filename, nil
+	return hunks, filename, nil
+}
+
+// GetDiffs helper
+func countLines(content string) int {
+	numOfLines := strings.Count(content, "\n")
+	if !strings.HasSuffix(content, "\n") {
+		numOfLines++
+	}
+	return numOfLines
+}
+
+// GetDiffs helper, trims the content of a hunk and moves its' start line past any trimmed lines.
+func newHunk(content string, startLine int, added bool) *Hunk {
+	trimmed := strings.TrimLeft(content, " \n")
+	startLine += strings.Count(content[:len(content)-len(trimmed)], "\n")
+	return &Hunk{
+		Content:   strings.TrimRight(trimmed, " \n"),
+		StartLine: startLine,
+		Added:     added,
+	}
+}
+
+// changeName returns the path of the file a given change changes.
+func changeName(change *object.Change) string {
+	if change.To.Name != "" {
+		return change.To.Name
+	}
+	return change.From.Name
 }
 
 // GetDiffs helper
 func getFilepath(file diff.FilePatch) string {
 	from, to := file.Files()
-	if from != nil {
-		return from.Path()
+	if to != nil {
+		return to.Path()
 	}
-	return to.Path()
+	return from.Path()
 }
 
 // GetDiffs helper
@@ -206,3 +636,65 @@ func blacklistedFile(m *Middleware, filename string) bool {
 	}
 	return false
 }
+
+// readIndex reads the index of a given repository. Git points hooks at a temporary
+// index through GIT_INDEX_FILE when only some files are committed, i.e. git commit -a.
+func readIndex(repo *git.Repository) (*index.Index, error) {
+	indexFile := os.Getenv("GIT_INDEX_FILE")
+	if indexFile == "" {
+		return repo.Storer.Index()
+	}
+	file, err := os.Open(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	idx := &index.Index{}
+	return idx, index.NewDecoder(file).Decode(idx)
+}
+
+// headTree returns the tree of the commit HEAD points to, which is empty before the first commit.
+func headTree(repo *git.Repository) (*object.Tree, error) {
+	head, err := repo.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return &object.Tree{Entries: []object.TreeEntry{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+// readBlob reads the contents of the blob with a given hash.
+func readBlob(repo *git.Repository, hash plumbing.Hash) ([]byte, error) {
+	blob, err := repo.BlobObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// addedHunks diffs two versions of a file and returns the hunks which were added to the new version.
+func addedHunks(old string, new string) []*Hunk {
+	var hunks []*Hunk
+	newLine := 1
+	for _, chunk := range diffutil.Do(old, new) {
+		switch chunk.Type {
+		case diffmatchpatch.DiffEqual:
+			newLine += countLines(chunk.Text)
+		case diffmatchpatch.DiffInsert:
+			hunks = append(hunks, newHunk(chunk.Text, newLine, true))
+			newLine += countLines(chunk.Text)
+		}
+	}
+	return hunks
+}
@@ -9,21 +9,23 @@ import (
 	"strings"
 )
 
-func handleGithubError(m *Middleware, err error, name string) {
+// handleGithubError turns an error of Github's API into an AuthError, NotFoundError
+// or RateLimitError where applicable.
+func handleGithubError(err error, name string) error {
 	if err == nil {
-		return
+		return nil
 	}
-	if _, ok := err.(*github.RateLimitError); ok {
-		m.Logger.LogWarn("Hit Github rate limit.\n")
-		return
+	switch err.(type) {
+	case *github.RateLimitError, *github.AbuseRateLimitError:
+		return &RateLimitError{Name: name, Err: err}
 	}
 	if strings.Contains(err.Error(), "Bad credentials") {
-		m.Logger.LogFail("Github token is invalid!\n")
+		return &AuthError{Err: err}
 	}
 	if strings.Contains(err.Error(), "Not Found") {
-		m.Logger.LogFail("%s does not exist.\n", name)
+		return &NotFoundError{Name: name, Err: err}
 	}
-	m.Logger.LogFail("%s\n", err)
+	return err
 }
 
 // getCachedUserOrOrg retrieves cached repos under user or org.
@@ -76,22 +78,29 @@ func getCachedOrgMembers(orgname string) []*string {
 }
 
 // GetUserRepos returns all non forked public repositories for a given user.
-func GetUserRepos(m *Middleware, username string) []*string {
+// If the rate limit is hit the repositories listed before it are returned along with a RateLimitError.
+func GetUserRepos(ctx context.Context, m *Middleware, username string) ([]*string, error) {
 	cache := getCachedUserOrOrg(m, username)
 	if !*m.Flags.NoCache && !*m.Flags.NoBare && len(cache) != 0 {
-		return cache
+		return cache, nil
 	}
 
 	cloneURLs := []*string{}
 	opt := &github.RepositoryListOptions{Type: "public", ListOptions: github.ListOptions{PerPage: 100}}
 	for {
-		repos, resp, err := m.Client.Repositories.List(context.Background(), username, opt)
-		handleGithubError(m, err, username)
+		repos, resp, err := m.Client.Repositories.List(ctx, username, opt)
+		if err != nil {
+			return cloneURLs, handleGithubError(err, username)
+		}
 
 		for _, repo := range repos {
 			if *repo.Fork && !*m.Flags.Forks {
 				continue
 			}
+			// Github gives the size of repositories in KB
+			if tooLarge(m, repo.GetCloneURL(), int64(repo.GetSize())<<10) {
+				continue
+			}
 			cloneURLs = append(cloneURLs, repo.CloneURL)
 		}
 		if resp.NextPage == 0 {
@@ -99,26 +108,33 @@ func GetUserRepos(m *Middleware, username string) []*string {
 		}
 		opt.Page = resp.NextPage
 	}
-	return cloneURLs
+	return cloneURLs, nil
 }
 
 // GetOrgRepos returns all repositories of a given organization.
-func GetOrgRepos(m *Middleware, orgname string) []*string {
+// If the rate limit is hit the repositories listed before it are returned along with a RateLimitError.
+func GetOrgRepos(ctx context.Context, m *Middleware, orgname string) ([]*string, error) {
 	cache := getCachedUserOrOrg(m, orgname)
 	if !*m.Flags.NoCache && !*m.Flags.NoBare && len(cache) != 0 {
-		return cache
+		return cache, nil
 	}
 
 	cloneURLs := []*string{}
 	opt := &github.RepositoryListByOrgOptions{ListOptions: github.ListOptions{PerPage: 100}}
 	for {
-		repos, resp, err := m.Client.Repositories.ListByOrg(context.Background(), orgname, opt)
-		handleGithubError(m, err, orgname)
+		repos, resp, err := m.Client.Repositories.ListByOrg(ctx, orgname, opt)
+		if err != nil {
+			return cloneURLs, handleGithubError(err, orgname)
+		}
 
 		for _, repo := range repos {
 			if *repo.Fork && !*m.Flags.Forks {
 				continue
 			}
+			// Github gives the size of repositories in KB
+			if tooLarge(m, repo.GetCloneURL(), int64(repo.GetSize())<<10) {
+				continue
+			}
 			cloneURLs = append(cloneURLs, repo.CloneURL)
 		}
 		if resp.NextPage == 0 {
@@ -126,21 +142,24 @@ func GetOrgRepos(m *Middleware, orgname string) []*string {
 		}
 		opt.Page = resp.NextPage
 	}
-	return cloneURLs
+	return cloneURLs, nil
 }
 
 // GetOrgMembers returns all members of a given organization.
-func GetOrgMembers(m *Middleware, orgname string) []*string {
+// If the rate limit is hit the members listed before it are returned along with a RateLimitError.
+func GetOrgMembers(ctx context.Context, m *Middleware, orgname string) ([]*string, error) {
 	cache := getCachedOrgMembers(orgname)
 	if !*m.Flags.NoCache && !*m.Flags.NoBare && len(cache) != 0 {
-		return cache
+		return cache, nil
 	}
 
 	usernames := []*string{}
 	opt := &github.ListMembersOptions{ListOptions: github.ListOptions{PerPage: 100}}
 	for {
-		members, resp, err := m.Client.Organizations.ListMembers(context.Background(), orgname, opt)
-		handleGithubError(m, err, orgname)
+		members, resp, err := m.Client.Organizations.ListMembers(ctx, orgname, opt)
+		if err != nil {
+			return usernames, handleGithubError(err, orgname)
+		}
 
 		for _, member := range members {
 			usernames = append(usernames, member.Login)
@@ -156,5 +175,5 @@ func GetOrgMembers(m *Middleware, orgname string) []*string {
 	if err != nil {
 		m.Logger.LogWarn("Failed to save org members of %s due to: %s\n", orgname, err)
 	}
-	return usernames
+	return usernames, nil
 }
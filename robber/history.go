@@ -0,0 +1,134 @@
+package robber
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// historyIter walks the history of given commits from the oldest commit to the newest, so each
+// commit comes after its' parents. It is a depth first search which yields a commit once all of its'
+// parents have been yielded, so it only holds the hashes of the commits on its' path and of the
+// commits it has visited in memory. Tips and parents are walked oldest first by commit date, which
+// keeps commits close to the order they were made in.
+//
+// Commits in pruned are left out along with their history, as are commits more than depth commits
+// away from the closest tip unless depth is negative. Commits made before since are walked through
+// but neither yielded nor counted towards the depth. A commit which is reached by a shorter path
+// after it was yielded has its' parents walked again, so commits which only that path brings within
+// the depth are yielded after their children.
+type historyIter struct {
+	ctx    context.Context
+	repo   *git.Repository
+	pruned map[plumbing.Hash]bool
+	since  time.Time
+	depth  int
+	tips   []plumbing.Hash
+	// depths holds for each visited commit the least number of counted commits between it and a tip
+	depths map[plumbing.Hash]int
+	path   []*historyStep
+}
+
+// historyStep is a commit on the path of a historyIter along with the parents it has yet to walk.
+// Depth is the number of counted commits on the path up to and including the commit and yield is
+// whether the commit is yielded once its' parents have been walked.
+type historyStep struct {
+	hash    plumbing.Hash
+	parents []plumbing.Hash
+	depth   int
+	yield   bool
+}
+
+// newHistoryIter returns a historyIter walking the history of given tips, which stops early once
+// the given context is done.
+func newHistoryIter(ctx context.Context, repo *git.Repository, tips []*object.Commit,
+	pruned map[plumbing.Hash]bool, since time.Time, depth int) *historyIter {
+	return &historyIter{
+		ctx:    ctx,
+		repo:   repo,
+		pruned: pruned,
+		since:  since,
+		depth:  depth,
+		tips:   oldestFirst(tips),
+		depths: make(map[plumbing.Hash]int),
+	}
+}
+
+// Next returns the hash of the next commit, or false once the whole history has been walked.
+func (h *historyIter) Next() (plumbing.Hash, bool) {
+	for h.ctx.Err() == nil {
+		if len(h.path) == 0 {
+			if len(h.tips) == 0 {
+				break
+			}
+			h.visit(h.tips[0], 0)
+			h.tips = h.tips[1:]
+			continue
+		}
+		step := h.path[len(h.path)-1]
+		if len(step.parents) != 0 {
+			h.visit(step.parents[0], step.depth)
+			step.parents = step.parents[1:]
+			continue
+		}
+		h.path = h.path[:len(h.path)-1]
+		if step.yield {
+			return step.hash, true
+		}
+	}
+	return plumbing.ZeroHash, false
+}
+
+// visit adds the commit of a given hash to the path, unless it was visited before by a path as short,
+// it is pruned or it is too far away from its' tip given the depth of its' child.
+func (h *historyIter) visit(hash plumbing.Hash, depth int) {
+	if h.pruned[hash] {
+		return
+	}
+	closest, visited := h.depths[hash]
+	if visited && (h.depth < 0 || depth >= closest) {
+		return
+	}
+	commit, err := h.repo.CommitObject(hash)
+	if err != nil {
+		// Parents are missing at the boundary of shallow clones
+		return
+	}
+	counted := !commit.Committer.When.Before(h.since)
+	if counted && h.depth >= 0 && depth >= h.depth {
+		return
+	}
+	h.depths[hash] = depth
+	if counted {
+		depth++
+	}
+
+	parents := commit.ParentHashes
+	if len(parents) > 1 {
+		var commits []*object.Commit
+		for _, parent := range parents {
+			if commit, err := h.repo.CommitObject(parent); err == nil {
+				commits = append(commits, commit)
+			}
+		}
+		parents = oldestFirst(commits)
+	}
+	h.path = append(h.path, &historyStep{hash: hash, parents: parents, depth: depth, yield: counted && !visited})
+}
+
+// oldestFirst orders given commits from the oldest to the newest by their commit date and returns their hashes.
+func oldestFirst(commits []*object.Commit) []plumbing.Hash {
+	commits = append([]*object.Commit(nil), commits...)
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].Committer.When.Before(commits[j].Committer.When)
+	})
+	hashes := make([]plumbing.Hash, len(commits))
+	for index, commit := range commits {
+		hashes[index] = commit.Hash
+	}
+	return hashes
+}
@@ -0,0 +1,175 @@
+package robber
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4/helper/mount"
+	"gopkg.in/src-d/go-billy.v4/helper/polyfill"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+// Hooks yar can run as, given as the first argument after "yar hook".
+const (
+	// HookPreCommit scans the changes staged for the next commit.
+	HookPreCommit = "pre-commit"
+	// HookPreReceive scans the commits pushed to a repository on a git server.
+	HookPreReceive = "pre-receive"
+	// HookInstall installs yar as the pre-commit hook of the current repository.
+	HookInstall = "install"
+)
+
+// Marks hooks installed by yar, other hooks are never overwritten.
+const hookMarker = "# Installed by yar"
+
+// hookUsage describes the hook commands of yar.
+const hookUsage = `usage: yar hook pre-commit [flags]
+       yar hook pre-receive [flags]
+       yar hook install [flags]
+
+pre-commit scans the changes staged in the repository of the current directory
+and exits with 1 if they contain secrets. install installs yar as the pre-commit
+hook of the repository, running it with the given flags. pre-receive scans the
+commits of the ref updates given on stdin by git and exits with 1 if they add
+secrets, rejecting the push.
+`
+
+// validHook checks whether yar can run as the given hook.
+func validHook(hook string) bool {
+	return hook == HookPreCommit || hook == HookPreReceive || hook == HookInstall
+}
+
+// openWorkingRepo opens the repository the current directory is within.
+func openWorkingRepo() (*git.Repository, error) {
+	repo, err := git.PlainOpenWithOptions(".", &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open the repository of the current directory: %w", err)
+	}
+	return repo, nil
+}
+
+// shellQuote quotes a given argument for a shell script.
+func shellQuote(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// InstallHook writes a pre-commit hook running yar with the given arguments into the repository
+// of the current directory. An existing hook is only overwritten if it was installed by yar.
+func InstallHook(args []string) error {
+	repo, err := openWorkingRepo()
+	if err != nil {
+		return err
+	}
+	storage, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return fmt.Errorf("Unable to find the hooks folder of the current repository")
+	}
+	hooksDir := filepath.Join(storage.Filesystem().Root(), "hooks")
+	hookPath := filepath.Join(hooksDir, HookPreCommit)
+	if content, err := ioutil.ReadFile(hookPath); err == nil && !strings.Contains(string(content), hookMarker) {
+		return fmt.Errorf("A %s hook already exists at %s, remove it to install yar's", HookPreCommit, hookPath)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		executable = "yar"
+	}
+	command := []string{shellQuote(executable), "hook", HookPreCommit}
+	for _, arg := range args {
+		command = append(command, shellQuote(arg))
+	}
+	script := fmt.Sprintf("#!/bin/sh\n%s, blocks commits which add secrets\nexec %s\n", hookMarker, strings.Join(command, " "))
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(hookPath, []byte(script), 0755)
+}
+
+// RefUpdate is a reference update of a push, as given to a pre-receive hook.
+// Old is the zero hash for new references and New is the zero hash for deleted references.
+type RefUpdate struct {
+	Old plumbing.Hash
+	New plumbing.Hash
+	Ref string
+}
+
+// ReadRefUpdates reads the "old new ref" lines git gives a pre-receive hook.
+func ReadRefUpdates(reader io.Reader) ([]*RefUpdate, error) {
+	var updates []*RefUpdate
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, &UsageError{Usage: fmt.Sprintf("Invalid ref update %q, expected \"old new ref\"\n", scanner.Text())}
+		}
+		updates = append(updates, &RefUpdate{
+			Old: plumbing.NewHash(fields[0]),
+			New: plumbing.NewHash(fields[1]),
+			Ref: fields[2],
+		})
+	}
+	return updates, scanner.Err()
+}
+
+// quarantineStorage reads objects from git's quarantine directory before the repository's own objects.
+type quarantineStorage struct {
+	*filesystem.Storage
+	incoming *filesystem.Storage
+}
+
+func (s *quarantineStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.incoming.EncodedObject(t, h)
+	if err == plumbing.ErrObjectNotFound {
+		return s.Storage.EncodedObject(t, h)
+	}
+	return obj, err
+}
+
+func (s *quarantineStorage) HasEncodedObject(h plumbing.Hash) error {
+	if err := s.incoming.HasEncodedObject(h); err != plumbing.ErrObjectNotFound {
+		return err
+	}
+	return s.Storage.HasEncodedObject(h)
+}
+
+// openPushedRepo opens the repository a pre-receive hook runs in. Git keeps the pushed objects
+// in a quarantine directory until the hook accepts them, those are read along with the objects
+// of the repository itself.
+func openPushedRepo() (*git.Repository, string, error) {
+	dir := os.Getenv("GIT_DIR")
+	if dir == "" {
+		dir = "."
+	}
+	dir, _ = filepath.Abs(dir)
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to open the repository %s: %w", dir, err)
+	}
+	quarantine := os.Getenv("GIT_QUARANTINE_PATH")
+	storage, ok := repo.Storer.(*filesystem.Storage)
+	if quarantine == "" || !ok {
+		return repo, dir, nil
+	}
+
+	// The quarantine directory is laid out like the objects directory of a repository
+	objects := mount.New(memfs.New(), "objects", osfs.New(quarantine))
+	incoming := filesystem.NewStorage(polyfill.New(objects), cache.NewObjectLRUDefault())
+	repo, err = git.Open(&quarantineStorage{Storage: storage, incoming: incoming}, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to open the repository %s: %w", dir, err)
+	}
+	return repo, dir, nil
+}
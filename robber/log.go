@@ -1,10 +1,10 @@
 package robber
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +22,13 @@ const (
 	fail
 )
 
+const (
+	// Interval between redraws of the progress
+	progressInterval = 200 * time.Millisecond
+	// Lines of the progress are cut to fit a terminal of this width
+	progressWidth = 80
+)
+
 const seperator = "--------------------------------------------------------"
 
 var validColors = map[string]*color.Color{
@@ -54,16 +61,29 @@ var logColors = map[int]*color.Color{
 	fail:    color.New(color.FgRed).Add(color.Bold),
 }
 
-type jsonFinding []struct {
-	Reason        string `json:"Reason"`
-	Filepath      string `json:"Filepath"`
-	RepoName      string `json:"RepoName"`
-	Commiter      string `json:"Commiter"`
-	CommitHash    string `json:"CommitHash"`
-	DateOfCommit  string `json:"DateOfCommit"`
-	CommitMessage string `json:"CommitMessage"`
-	Source        string `json:"Source"`
-	Secret        string `json:"Secret"`
+type jsonExposure struct {
+	IntroducedCommit string `json:"IntroducedCommit"`
+	DateIntroduced   string `json:"DateIntroduced"`
+	RemovedCommit    string `json:"RemovedCommit"`
+	DateRemoved      string `json:"DateRemoved"`
+	StillAtHead      bool   `json:"StillAtHead"`
+	ExposedDays      int    `json:"ExposedDays"`
+}
+
+type jsonFinding struct {
+	Reason        string        `json:"Reason"`
+	Filepath      string        `json:"Filepath"`
+	LineNumber    int           `json:"LineNumber"`
+	ChangeType    string        `json:"ChangeType"`
+	RepoName      string        `json:"RepoName"`
+	Commiter      string        `json:"Commiter"`
+	CommitHash    string        `json:"CommitHash"`
+	DateOfCommit  string        `json:"DateOfCommit"`
+	CommitMessage string        `json:"CommitMessage"`
+	Source        string        `json:"Source"`
+	Secret        string        `json:"Secret"`
+	Refs          []string      `json:"Refs,omitempty"`
+	Exposure      *jsonExposure `json:"Exposure,omitempty"`
 }
 
 // Finding struct contains data of a given secret finding, used for later output of a finding.
@@ -78,12 +98,19 @@ type Finding struct {
 	Diff          string
 	RepoName      string
 	Filepath      string
+	LineNumber    int
+	Added         bool
+	Refs          []string
+	Exposure      *Exposure
 }
 
 // Logger handles all logging to the output.
 type Logger struct {
 	sync.Mutex
-	Verbose bool
+	Verbose       bool
+	progressLines int
+	stopProgress  chan bool
+	progressDone  chan bool
 }
 
 func setColors() {
@@ -112,48 +139,83 @@ func NewLogger(verbose bool) *Logger {
 }
 
 // NewFinding simply returns a new finding struct.
-func NewFinding(reason string, secret []int, diffObject *DiffObject) *Finding {
+// The given line is the index of the line within the diff the secret was found on.
+// Findings of diffs without a commit, i.e. of files in a directory, leave the commit fields empty.
+func NewFinding(reason string, secret []int, line int, diffObject *DiffObject) *Finding {
 	finding := &Finding{
-		CommitHash:    diffObject.Commit.Hash.String(),
-		CommitMessage: diffObject.Commit.Message,
-		Committer:     diffObject.Commit.Committer.Name,
-		DateOfCommit:  diffObject.Commit.Committer.When.Format(time.RFC1123),
-		Email:         diffObject.Commit.Committer.Email,
-		Reason:        reason,
-		Secret:        secret,
-		RepoName:      *diffObject.Reponame,
-		Filepath:      *diffObject.Filepath,
+		Reason:     reason,
+		Secret:     secret,
+		RepoName:   *diffObject.Reponame,
+		Filepath:   *diffObject.Filepath,
+		LineNumber: diffObject.StartLine + line,
+		Added:      diffObject.Added,
+		Refs:       diffObject.Refs,
+	}
+	if commit := diffObject.Commit; commit != nil {
+		finding.CommitHash = commit.Hash.String()
+		finding.CommitMessage = commit.Message
+		finding.Committer = commit.Committer.Name
+		finding.DateOfCommit = commit.Committer.When.Format(time.RFC1123)
+		finding.Email = commit.Committer.Email
 	}
 	return finding
 }
 
+// changeType describes whether a secret was added or removed by a commit.
+func changeType(added bool) string {
+	if added {
+		return "added"
+	}
+	return "removed"
+}
+
 func saveFindingsHelper(repoName string, hash string, filePath string) string {
+	if hash == "" {
+		return filepath.Join(repoName, filePath)
+	}
 	if strings.HasPrefix(repoName, "/tmp") {
 		return fmt.Sprintf("git --git-dir=%s show %s:%s", repoName, hash[:6], filePath)
 	}
 	return strings.Join([]string{repoName, "commit", hash}, "/")
 }
 
-// SaveFindings saves all findings to a JSON file named findings.json
-func SaveFindings(m *Middleware) {
-	var savedFindings jsonFinding
-	for _, finding := range m.Findings {
-		repoName := strings.Replace(finding.RepoName, ".git", "", 1)
-		source := saveFindingsHelper(repoName, finding.CommitHash, finding.Filepath)
-		savedFindings = append(savedFindings, jsonFinding{{
-			Reason:        finding.Reason,
-			Filepath:      finding.Filepath,
-			RepoName:      repoName,
-			Commiter:      finding.Committer,
-			CommitHash:    finding.CommitHash,
-			DateOfCommit:  finding.DateOfCommit,
-			CommitMessage: finding.CommitMessage,
-			Source:        source,
-			Secret:        finding.Diff[finding.Secret[0]:finding.Secret[1]],
-		}}...)
-	}
-	content, _ := json.MarshalIndent(savedFindings, "", "  ")
-	_ = ioutil.WriteFile(*m.Flags.Save, content, 0644)
+// formatDate formats the date of an exposure, leaving unknown dates empty.
+func formatDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(time.RFC1123)
+}
+
+// newJSONFinding converts a finding to the JSON format it is saved in. The exposure of the
+// secret is only included if asked for, as it is only complete once the whole repo has been scanned.
+func newJSONFinding(finding *Finding, withExposure bool) *jsonFinding {
+	repoName := strings.Replace(finding.RepoName, ".git", "", 1)
+	saved := &jsonFinding{
+		Reason:        finding.Reason,
+		Filepath:      finding.Filepath,
+		LineNumber:    finding.LineNumber,
+		ChangeType:    changeType(finding.Added),
+		RepoName:      repoName,
+		Commiter:      finding.Committer,
+		CommitHash:    finding.CommitHash,
+		DateOfCommit:  finding.DateOfCommit,
+		CommitMessage: finding.CommitMessage,
+		Source:        saveFindingsHelper(repoName, finding.CommitHash, finding.Filepath),
+		Secret:        finding.Diff[finding.Secret[0]:finding.Secret[1]],
+		Refs:          finding.Refs,
+	}
+	if exposure := finding.Exposure; withExposure && exposure != nil {
+		saved.Exposure = &jsonExposure{
+			IntroducedCommit: exposure.IntroducedCommit,
+			DateIntroduced:   formatDate(exposure.IntroducedDate),
+			RemovedCommit:    exposure.RemovedCommit,
+			DateRemoved:      formatDate(exposure.RemovedDate),
+			StillAtHead:      exposure.AtHead,
+			ExposedDays:      int(exposure.Window().Hours() / 24),
+		}
+	}
+	return saved
 }
 
 func (l *Logger) log(level int, format string, a ...interface{}) {
@@ -162,15 +224,12 @@ func (l *Logger) log(level int, format string, a ...interface{}) {
 	if level == verbose && l.Verbose == false {
 		return
 	}
+	l.clearProgress()
 
 	if c, ok := logColors[level]; ok {
 		c.Printf(format, a...)
 	} else {
-		fmt.Printf(format, a...)
-	}
-
-	if level == fail {
-		os.Exit(1)
+		fmt.Fprintf(color.Output, format, a...)
 	}
 }
 
@@ -184,26 +243,40 @@ func (l *Logger) logSecret(diff string, booty []int, contextNum int) {
 }
 
 // LogFinding is used to output Findings
-func (l *Logger) LogFinding(f *Finding, m *Middleware, contextDiff string) {
+func (l *Logger) LogFinding(f *Finding, m *Middleware) {
 	l.Lock()
 	defer l.Unlock()
-	f.Diff = contextDiff
-	m.Append(f)
+	l.clearProgress()
 
 	info, _ := logColors[info]
 	data, _ := logColors[data]
-	secret, _ := logColors[secret]
 	repoPath, _ := GetDir(f.RepoName)
 
 	info.Println(seperator)
 	info.Printf("Reason: ")
 	data.Println(f.Reason)
+	if f.CommitHash == "" {
+		info.Printf("Filepath: ")
+		data.Println(f.Filepath)
+		info.Printf("Line: ")
+		data.Printf("%d (%s)\n", f.LineNumber, changeType(f.Added))
+		info.Printf("Source: ")
+		data.Printf("%s\n\n", f.RepoName)
+		l.logFindingSecret(f, m)
+		return
+	}
 	if f.Filepath != "" {
 		info.Printf("Filepath: ")
 		data.Println(f.Filepath)
+		info.Printf("Line: ")
+		data.Printf("%d (%s)\n", f.LineNumber, changeType(f.Added))
 	}
 	info.Printf("Repo name: ")
 	data.Println(strings.Replace(f.RepoName, ".git", "", 1))
+	if len(f.Refs) != 0 {
+		info.Printf("Refs: ")
+		data.Println(strings.Join(f.Refs, ", "))
+	}
 	info.Printf("Committer: ")
 	data.Printf("%s (%s)\n", f.Committer, f.Email)
 	info.Printf("Commit hash: ")
@@ -214,13 +287,210 @@ func (l *Logger) LogFinding(f *Finding, m *Middleware, contextDiff string) {
 	data.Println(f.DateOfCommit)
 	info.Printf("Commit message: ")
 	data.Printf("%s\n\n", strings.Trim(f.CommitMessage, "\n"))
+	l.logFindingSecret(f, m)
+}
+
+// logFindingSecret outputs the secret of a finding, along with its' context unless told otherwise.
+func (l *Logger) logFindingSecret(f *Finding, m *Middleware) {
 	if *m.Flags.NoContext {
-		secret.Printf("%s\n\n", contextDiff[f.Secret[0]:f.Secret[1]])
+		secret, _ := logColors[secret]
+		secret.Printf("%s\n\n", f.Diff[f.Secret[0]:f.Secret[1]])
 	} else {
 		l.logSecret(f.Diff, f.Secret, *m.Flags.Context)
 	}
 }
 
+// LogCompactFinding is used to output a finding on a single line, i.e. file:line: reason: secret
+// The line starts with the abbreviated hash of the commit the finding was found in, if any.
+func (l *Logger) LogCompactFinding(f *Finding) {
+	l.Lock()
+	defer l.Unlock()
+	l.clearProgress()
+
+	info, _ := logColors[info]
+	data, _ := logColors[data]
+	secret, _ := logColors[secret]
+
+	if f.CommitHash != "" {
+		info.Printf("%s ", f.CommitHash[:7])
+	}
+	data.Printf("%s:%d: ", f.Filepath, f.LineNumber)
+	info.Printf("%s: ", f.Reason)
+	secret.Println(f.Diff[f.Secret[0]:f.Secret[1]])
+}
+
+// LogHookResult is used to output why a given hook rejected the changes it scanned, if it did.
+func (l *Logger) LogHookResult(hook string, summary *Summary) {
+	if summary.FailingFindings == 0 {
+		return
+	}
+	if hook == HookPreReceive {
+		l.LogFail("Push rejected, yar found %d secret(s) in the pushed commits.\n", summary.FailingFindings)
+		l.LogFail("Remove them from the commits and push again\n")
+		return
+	}
+	l.LogFail("Commit blocked, yar found %d secret(s) in the staged changes.\n", summary.FailingFindings)
+	l.LogFail("Remove them, allow them in the config or skip the check with git commit --no-verify\n")
+}
+
+// LogExposures is used to output the exposure of every unique secret found within a repo.
+func (l *Logger) LogExposures(reponame string, exposures map[string]*Exposure) {
+	l.Lock()
+	defer l.Unlock()
+	l.clearProgress()
+
+	info, _ := logColors[info]
+	data, _ := logColors[data]
+	secret, _ := logColors[secret]
+
+	sorted := make([]*Exposure, 0, len(exposures))
+	for _, exposure := range exposures {
+		sorted = append(sorted, exposure)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].IntroducedDate.Before(sorted[j].IntroducedDate)
+	})
+
+	info.Println(seperator)
+	info.Printf("Exposure of secrets in ")
+	data.Printf("%s\n\n", strings.Replace(reponame, ".git", "", 1))
+	for _, exposure := range sorted {
+		secret.Printf("%s", exposure.Secret)
+		data.Printf(" (%s)\n", exposure.Reason)
+		info.Printf("Introduced: ")
+		if exposure.Introduced() {
+			data.Printf("%s (%s)\n", exposure.IntroducedCommit, formatDate(exposure.IntroducedDate))
+		} else {
+			data.Println("before the scanned history")
+		}
+		info.Printf("Removed: ")
+		if exposure.AtHead {
+			data.Println("no, still present at HEAD")
+		} else if exposure.Removed() {
+			data.Printf("%s (%s)\n", exposure.RemovedCommit, formatDate(exposure.RemovedDate))
+		} else {
+			data.Println("no longer present at HEAD")
+		}
+		if exposure.Introduced() {
+			info.Printf("Exposed for: ")
+			data.Printf("%d days\n", int(exposure.Window().Hours()/24))
+		}
+		fmt.Fprintln(color.Output)
+	}
+}
+
+// LogSkipped is used to output the repositories which were skipped along with the reason why.
+func (l *Logger) LogSkipped(skipped []*SkippedRepo) {
+	if len(skipped) == 0 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.clearProgress()
+
+	info, _ := logColors[info]
+	data, _ := logColors[data]
+
+	info.Println(seperator)
+	info.Printf("Skipped repositories\n\n")
+	for _, repo := range skipped {
+		data.Printf("%s: ", repo.Name)
+		info.Println(repo.Reason)
+	}
+	fmt.Fprintln(color.Output)
+}
+
+// LogSummary is used to output the summary of a finished scan.
+func (l *Logger) LogSummary(summary *Summary) {
+	l.Lock()
+	defer l.Unlock()
+	l.clearProgress()
+
+	info, _ := logColors[info]
+	data, _ := logColors[data]
+
+	reasons := make([]string, 0, len(summary.FindingsPerRule))
+	for reason := range summary.FindingsPerRule {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	info.Println(seperator)
+	info.Printf("Summary\n\n")
+	info.Printf("Repos: ")
+	data.Printf("%d scanned, %d skipped, %d empty, %d failed\n",
+		summary.ReposScanned, summary.ReposSkipped, summary.ReposEmpty, summary.ReposFailed)
+	info.Printf("Commits analyzed: ")
+	data.Println(summary.CommitsAnalyzed)
+	if summary.FilesAnalyzed != 0 {
+		info.Printf("Files analyzed: ")
+		data.Println(summary.FilesAnalyzed)
+	}
+	info.Printf("Findings: ")
+	data.Println(summary.Findings)
+	for _, reason := range reasons {
+		data.Printf("    %s: %d\n", reason, summary.FindingsPerRule[reason])
+	}
+	info.Printf("Elapsed time: ")
+	data.Println(time.Duration(summary.ElapsedSeconds * float64(time.Second)).Round(time.Millisecond))
+	fmt.Fprintln(color.Output)
+}
+
+// ShowProgress redraws the lines given by progress on stderr until StopProgress is called.
+// The progress is cleared whenever the logger outputs anything else.
+func (l *Logger) ShowProgress(progress func() []string) {
+	l.stopProgress = make(chan bool)
+	l.progressDone = make(chan bool)
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.Lock()
+				l.clearProgress()
+				l.drawProgress(progress())
+				l.Unlock()
+			case <-l.stopProgress:
+				l.Lock()
+				l.clearProgress()
+				l.Unlock()
+				close(l.progressDone)
+				return
+			}
+		}
+	}()
+}
+
+// StopProgress stops and clears the progress shown by ShowProgress.
+func (l *Logger) StopProgress() {
+	if l.stopProgress == nil {
+		return
+	}
+	close(l.stopProgress)
+	<-l.progressDone
+	l.stopProgress = nil
+}
+
+func (l *Logger) drawProgress(lines []string) {
+	for i, line := range lines {
+		if len(line) > progressWidth {
+			lines[i] = line[:progressWidth-3] + "..."
+		}
+	}
+	fmt.Fprint(os.Stderr, strings.Join(lines, "\n"))
+	l.progressLines = len(lines)
+}
+
+// clearProgress clears the lines of the progress, the logger must be locked.
+func (l *Logger) clearProgress() {
+	if l.progressLines == 0 {
+		return
+	}
+	fmt.Fprint(os.Stderr, "\r\033[K"+strings.Repeat("\033[1A\033[K", l.progressLines-1))
+	l.progressLines = 0
+}
+
 // LogVerbose prints to output using 'verbose' colors
 func (l *Logger) LogVerbose(format string, a ...interface{}) {
 	l.log(verbose, format, a...)
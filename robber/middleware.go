@@ -1,15 +1,22 @@
 package robber
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"regexp"
 	"runtime"
 	"sync"
-	"sync/atomic"
 
 	"github.com/google/go-github/github"
 )
 
+// SkippedRepo is a repository which was skipped, or only partially scanned, for exceeding a limit.
+type SkippedRepo struct {
+	Name   string `json:"Name"`
+	Reason string `json:"Reason"`
+}
+
 // Middleware handles all flags, rules, secrets and logging.
 // It essentially holds all values which will be accessed by multiple go routines.
 type Middleware struct {
@@ -17,31 +24,117 @@ type Middleware struct {
 	Logger      *Logger
 	Flags       *Flags
 	Rules       []*Rule
+	Prefilter   *Prefilter
+	Diffs       *DiffCache
 	Blacklist   []*regexp.Regexp
+	Allowlist   []*AllowRule
 	Secrets     map[string]map[string]bool
+	Exposures   map[string]map[string]*Exposure
 	Client      *github.Client
 	AccessToken string
-	RepoCount   *int32
 	Findings    []*Finding
+	Baseline    *Baseline
+	Errors      []error
+	Skipped     []*SkippedRepo
+	Stats       *Stats
+	Sources     []Source
+	Detectors   []Detector
+	Sinks       []Sink
+	sinkLock    sync.Mutex
 }
 
-// NewMiddleware creates a new Middleware and returns it.
-func NewMiddleware() *Middleware {
+// NewMiddleware creates a new Middleware from the CLI arguments and returns it.
+// If the CleanUp flag is given the cleanup is performed and nothing else is set up,
+// the same goes for installing the pre-commit hook.
+func NewMiddleware() (*Middleware, error) {
+	flags, err := ParseFlags()
+	if err != nil {
+		return nil, err
+	}
+	if flags.CleanUpPresent {
+		m := &Middleware{Flags: flags, Logger: NewLogger(false)}
+		return m, CleanUp(m)
+	}
+	if flags.Hook == HookInstall {
+		m := &Middleware{Flags: flags, Logger: NewLogger(false)}
+		return m, InstallHook(flags.HookArgs)
+	}
+	m, err := newMiddleware(flags, os.Getenv(envTokenVariable))
+	if err != nil {
+		return nil, err
+	}
+
+	switch m.Flags.Hook {
+	case HookPreCommit:
+		m.Sources = []Source{&StagedSource{}}
+	case HookPreReceive:
+		updates, err := ReadRefUpdates(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		m.Sources = []Source{&PushSource{Updates: updates}}
+	}
+	if *m.Flags.Repo != "" {
+		m.Sources = append(m.Sources, &RepoSource{Name: *m.Flags.Repo})
+	}
+	if *m.Flags.Path != "" {
+		m.Sources = append(m.Sources, &PathSource{Dir: *m.Flags.Path, Gitignore: *m.Flags.Gitignore})
+	}
+	if *m.Flags.StdinDiff {
+		m.Sources = append(m.Sources, &DiffSource{Reader: os.Stdin, Name: "stdin"})
+	}
+	if *m.Flags.Both {
+		m.Detectors = []Detector{&RegexDetector{}, &EntropyDetector{}}
+	} else if *m.Flags.Entropy {
+		m.Detectors = []Detector{&EntropyDetector{}}
+	} else {
+		m.Detectors = []Detector{&RegexDetector{}}
+	}
+	m.Sinks = []Sink{&ConsoleSink{}}
+	if m.Flags.Hook != "" {
+		m.Sinks = []Sink{&CompactSink{}}
+	}
+	if m.Flags.SavePresent {
+		switch *m.Flags.Format {
+		case "sarif":
+			m.Sinks = append(m.Sinks, &SarifSink{Filename: *m.Flags.Save})
+		case "jsonl":
+			sink, err := NewJSONLSink(*m.Flags.Save)
+			if err != nil {
+				return nil, err
+			}
+			m.Sinks = append(m.Sinks, sink)
+		default:
+			m.Sinks = append(m.Sinks, &JSONSink{Filename: *m.Flags.Save})
+		}
+	}
+	return m, nil
+}
+
+// newMiddleware creates a Middleware from given flags, parsing the config and
+// loading the baseline if one was given.
+func newMiddleware(flags *Flags, accessToken string) (*Middleware, error) {
 	m := &Middleware{
 		Secrets:   make(map[string]map[string]bool),
-		Flags:     ParseFlags(),
-		RepoCount: new(int32),
+		Exposures: make(map[string]map[string]*Exposure),
+		Flags:     flags,
+		Stats:     NewStats(),
+	}
+	if *flags.DedupRepos {
+		m.Diffs = NewDiffCache()
 	}
 	m.Logger = NewLogger(false)
-	// If CleanUp flag is given, handle immediately
-	if m.Flags.CleanUpPresent {
-		CleanUp(m)
+	if err := ParseConfig(m); err != nil {
+		return nil, err
+	}
+	if *m.Flags.Baseline != "" {
+		if err := LoadBaseline(m); err != nil {
+			return nil, err
+		}
 	}
-	ParseConfig(m)
-	accessToken, client := GetAccessToken(m)
 	m.AccessToken = accessToken
-	m.Client = github.NewClient(client)
-	return m
+	m.Client = github.NewClient(GetAccessClient(accessToken))
+	return m, nil
 }
 
 // AddSecret adds a new secret for a given repo.
@@ -54,6 +147,20 @@ func (m *Middleware) AddSecret(reponame string, secret string) {
 	m.Secrets[reponame][secret] = true
 }
 
+// AddError collects an error which occurred while scanning a repo.
+func (m *Middleware) AddError(err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.Errors = append(m.Errors, err)
+}
+
+// AddSkipped records a repository which was skipped for exceeding a limit.
+func (m *Middleware) AddSkipped(reponame string, reason string) {
+	m.Lock()
+	defer m.Unlock()
+	m.Skipped = append(m.Skipped, &SkippedRepo{Name: reponame, Reason: reason})
+}
+
 // SecretExists checks to see whether a given secret string has been noticed before or not.
 func (m *Middleware) SecretExists(reponame string, secret string) bool {
 	m.Lock()
@@ -61,54 +168,95 @@ func (m *Middleware) SecretExists(reponame string, secret string) bool {
 	return m.Secrets[reponame][secret]
 }
 
-// Append appends finding to Middlewares Findings array if save mode is enabled.
-func (m *Middleware) Append(finding *Finding) {
-	if m.Flags.SavePresent {
+// Report writes a given finding to every sink. The finding is also collected if the baseline is being updated.
+func (m *Middleware) Report(finding *Finding) {
+	m.sinkLock.Lock()
+	defer m.sinkLock.Unlock()
+	if *m.Flags.UpdateBaseline {
 		m.Findings = append(m.Findings, finding)
 	}
+	m.Stats.AddFinding(finding.Reason, failsOn(m, finding))
+	for _, sink := range m.Sinks {
+		if err := sink.Write(m, finding); err != nil {
+			m.Logger.LogWarn("Unable to write finding: %s\n", err)
+		}
+	}
+}
+
+// ReportExposures writes the exposure of the reported secrets of a given repository to every ExposureSink.
+func (m *Middleware) ReportExposures(reponame string, exposures map[string]*Exposure) {
+	m.sinkLock.Lock()
+	defer m.sinkLock.Unlock()
+	for _, sink := range m.Sinks {
+		exposureSink, ok := sink.(ExposureSink)
+		if !ok {
+			continue
+		}
+		if err := exposureSink.WriteExposures(m, reponame, exposures); err != nil {
+			m.Logger.LogWarn("Unable to write exposures: %s\n", err)
+		}
+	}
+}
+
+// Close closes every sink and updates the baseline if asked to.
+// The first error of closing a sink is returned.
+func (m *Middleware) Close() error {
+	var closeErr error
+	for _, sink := range m.Sinks {
+		if err := sink.Close(m); err != nil && closeErr == nil {
+			closeErr = fmt.Errorf("Unable to save findings: %w", err)
+		}
+	}
+	if *m.Flags.UpdateBaseline {
+		UpdateBaseline(m)
+	}
+	return closeErr
 }
 
-// Start handles the CLI args and starts yar accordingly.
-func (m *Middleware) Start(kill chan bool, finished chan<- bool, cleanup <-chan bool) {
+// Start scans the repositories of the given organization and user along with all sources.
+// An error is returned if the repositories of a given user or organization can't be listed,
+// errors of single sources are logged and collected instead. Once the given context is done
+// the scan stops, the workers finish and the error of the context is returned.
+func (m *Middleware) Start(ctx context.Context) error {
+	m.Stats.Begin()
+	defer m.Stats.Finish()
 	wg := new(sync.WaitGroup)
-	cpuCount := runtime.NumCPU()
+	workers := *m.Flags.Workers
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	}
 
-	quit := make(chan bool, cpuCount) // Channel is buffered to ensure deadlock doesn't happen
-	done := make(chan bool)
-	repoch := make(chan string, cpuCount)
+	repoch := make(chan Source, workers)
 	// Start all workers
-	for proc := 1; proc <= cpuCount; proc++ {
+	for proc := 1; proc <= workers; proc++ {
 		wg.Add(1)
-		go AnalyzeRepo(m, proc, repoch, quit, done, wg)
-	}
-	if *m.Flags.Org != "" {
-		AnalyzeOrg(m, *m.Flags.Org, repoch)
+		go AnalyzeRepo(ctx, m, proc, repoch, wg)
 	}
-	if *m.Flags.User != "" {
-		AnalyzeUser(m, *m.Flags.User, repoch)
-	}
-	if *m.Flags.Repo != "" {
-		atomic.AddInt32(m.RepoCount, 1)
-		repoch <- *m.Flags.Repo
+	err := m.sendSources(ctx, repoch)
+	close(repoch)
+	wg.Wait()
+	if err != nil {
+		return err
 	}
+	return ctx.Err()
+}
 
-	// Handle edge case of an org/user containing 0 repos.
-	if atomic.LoadInt32(m.RepoCount) == 0 {
-		os.Exit(0)
+// sendSources sends the repositories of the given organization and user along with all sources to the workers.
+func (m *Middleware) sendSources(ctx context.Context, repoch chan<- Source) error {
+	if *m.Flags.Org != "" {
+		if err := AnalyzeOrg(ctx, m, *m.Flags.Org, repoch); err != nil {
+			return err
+		}
 	}
-
-	// Clean up all workers
-	select {
-	case <-quit:
-		for proc := 0; proc < cpuCount; proc++ {
-			done <- true
+	if *m.Flags.User != "" {
+		if err := AnalyzeUser(ctx, m, *m.Flags.User, repoch); err != nil {
+			return err
 		}
-	case <-kill:
-		for proc := 0; proc < cpuCount; proc++ {
-			done <- true
+	}
+	for _, source := range m.Sources {
+		if err := sendSource(ctx, m, repoch, source); err != nil {
+			return err
 		}
-		finished <- true
-		<-cleanup
 	}
-	wg.Wait()
+	return nil
 }
@@ -0,0 +1,148 @@
+package robber
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// PatchFile holds the hunks a patch adds to and removes from a single file.
+type PatchFile struct {
+	Path  string
+	Hunks []*Hunk
+}
+
+// patchParser keeps track of where a patch is being parsed.
+type patchParser struct {
+	files    []*PatchFile
+	oldPath  string
+	file     *PatchFile
+	oldLine  int
+	newLine  int
+	oldLeft  int
+	newLeft  int
+	added    []string
+	removed  []string
+	addStart int
+	delStart int
+}
+
+// ParsePatch parses unified diff text, such as the output of git diff or a patch file,
+// into the hunks it adds and removes per file. Anything outside of the file diffs, such
+// as commit messages of git format-patch, is ignored.
+func ParsePatch(reader io.Reader) ([]*PatchFile, error) {
+	p := &patchParser{}
+	buffered := bufio.NewReader(reader)
+	for {
+		line, err := buffered.ReadString('\n')
+		if line != "" {
+			p.parseLine(strings.TrimRight(line, "\r\n"))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.flush()
+	return p.files, nil
+}
+
+func (p *patchParser) parseLine(line string) {
+	if p.oldLeft > 0 || p.newLeft > 0 {
+		p.parseHunkLine(line)
+		return
+	}
+	switch {
+	case strings.HasPrefix(line, "--- "):
+		p.oldPath = patchPath(line[4:])
+	case strings.HasPrefix(line, "+++ "):
+		path := patchPath(line[4:])
+		if path == "/dev/null" {
+			path = p.oldPath
+		}
+		p.file = &PatchFile{Path: path}
+		p.files = append(p.files, p.file)
+	case strings.HasPrefix(line, "@@ ") && p.file != nil:
+		p.parseHunkHeader(line)
+	}
+}
+
+// parseHunkHeader parses a hunk header of the form @@ -oldLine,oldCount +newLine,newCount @@
+func (p *patchParser) parseHunkHeader(line string) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return
+	}
+	p.oldLine, p.oldLeft = parseHunkRange(fields[1], "-")
+	p.newLine, p.newLeft = parseHunkRange(fields[2], "+")
+}
+
+func (p *patchParser) parseHunkLine(line string) {
+	switch {
+	case strings.HasPrefix(line, "+"):
+		if len(p.added) == 0 {
+			p.addStart = p.newLine
+		}
+		p.added = append(p.added, line[1:])
+		p.newLine++
+		p.newLeft--
+	case strings.HasPrefix(line, "-"):
+		if len(p.removed) == 0 {
+			p.delStart = p.oldLine
+		}
+		p.removed = append(p.removed, line[1:])
+		p.oldLine++
+		p.oldLeft--
+	case strings.HasPrefix(line, "\\"):
+		// "\ No newline at end of file"
+		return
+	default:
+		// Context lines, some tools strip the leading space of empty ones
+		p.flush()
+		p.oldLine++
+		p.newLine++
+		p.oldLeft--
+		p.newLeft--
+	}
+	if p.oldLeft <= 0 && p.newLeft <= 0 {
+		p.flush()
+	}
+}
+
+// flush adds the lines added and removed since the last context line as hunks of the current file.
+func (p *patchParser) flush() {
+	if len(p.removed) != 0 {
+		p.file.Hunks = append(p.file.Hunks, newHunk(strings.Join(p.removed, "\n"), p.delStart, false))
+	}
+	if len(p.added) != 0 {
+		p.file.Hunks = append(p.file.Hunks, newHunk(strings.Join(p.added, "\n"), p.addStart, true))
+	}
+	p.added, p.removed = nil, nil
+}
+
+// parseHunkRange parses the -line,count or +line,count part of a hunk header, the count defaults to 1.
+func parseHunkRange(field string, prefix string) (int, int) {
+	parts := strings.SplitN(strings.TrimPrefix(field, prefix), ",", 2)
+	line, _ := strconv.Atoi(parts[0])
+	count := 1
+	if len(parts) == 2 {
+		count, _ = strconv.Atoi(parts[1])
+	}
+	return line, count
+}
+
+// patchPath returns the path of a file from a ---/+++ line, without the a/ or b/ prefix of git
+// and without the timestamp diff -u adds.
+func patchPath(path string) string {
+	if tab := strings.Index(path, "\t"); tab != -1 {
+		path = path[:tab]
+	}
+	path = strings.Trim(path, "\"")
+	if strings.HasPrefix(path, "a/") || strings.HasPrefix(path, "b/") {
+		return path[2:]
+	}
+	return path
+}
@@ -0,0 +1,111 @@
+package robber
+
+// Prefilter finds which rules can match a diff by searching it for the keywords of the rules,
+// so rules whose keywords don't appear in a diff don't have to be run on it. Keywords are
+// matched ignoring the case of ASCII letters and rules without keywords are always run.
+//
+// All keywords are searched for in a single pass over the diff with an Aho-Corasick automaton.
+type Prefilter struct {
+	// Each node of the automaton is a state, the root being 0
+	next    []map[byte]int
+	fail    []int
+	outputs [][]*Rule
+	always  []*Rule
+}
+
+// NewPrefilter builds a Prefilter from the keywords of given rules.
+func NewPrefilter(rules []*Rule) *Prefilter {
+	p := &Prefilter{next: []map[byte]int{{}}, fail: []int{0}, outputs: [][]*Rule{nil}}
+	for _, rule := range rules {
+		if len(rule.Keywords) == 0 {
+			p.always = append(p.always, rule)
+			continue
+		}
+		for _, keyword := range rule.Keywords {
+			state := p.insert(keyword)
+			p.outputs[state] = append(p.outputs[state], rule)
+		}
+	}
+	p.link()
+	return p
+}
+
+// insert adds a keyword to the trie of the automaton and returns the state it ends in.
+func (p *Prefilter) insert(keyword string) int {
+	state := 0
+	for i := 0; i < len(keyword); i++ {
+		char := lowerASCII(keyword[i])
+		child, ok := p.next[state][char]
+		if !ok {
+			child = len(p.next)
+			p.next = append(p.next, map[byte]int{})
+			p.fail = append(p.fail, 0)
+			p.outputs = append(p.outputs, nil)
+			p.next[state][char] = child
+		}
+		state = child
+	}
+	return state
+}
+
+// link sets the failure link of each state to the state of its' longest proper suffix within the
+// trie, in breadth first order, and adds the outputs of that state to its' own.
+func (p *Prefilter) link() {
+	queue := []int{}
+	for _, child := range p.next[0] {
+		queue = append(queue, child)
+	}
+	for len(queue) != 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for char, child := range p.next[state] {
+			fail := p.fail[state]
+			for {
+				if target, ok := p.next[fail][char]; ok {
+					p.fail[child] = target
+					break
+				}
+				if fail == 0 {
+					break
+				}
+				fail = p.fail[fail]
+			}
+			p.outputs[child] = append(p.outputs[child], p.outputs[p.fail[child]]...)
+			queue = append(queue, child)
+		}
+	}
+}
+
+// Rules returns the rules which can match a given diff.
+func (p *Prefilter) Rules(diff string) map[*Rule]bool {
+	rules := make(map[*Rule]bool, len(p.always))
+	for _, rule := range p.always {
+		rules[rule] = true
+	}
+	state := 0
+	for i := 0; i < len(diff); i++ {
+		char := lowerASCII(diff[i])
+		for {
+			if target, ok := p.next[state][char]; ok {
+				state = target
+				break
+			}
+			if state == 0 {
+				break
+			}
+			state = p.fail[state]
+		}
+		for _, rule := range p.outputs[state] {
+			rules[rule] = true
+		}
+	}
+	return rules
+}
+
+// lowerASCII returns the lower case of a given ASCII letter, other bytes are returned as is.
+func lowerASCII(char byte) byte {
+	if 'A' <= char && char <= 'Z' {
+		return char + 'a' - 'A'
+	}
+	return char
+}
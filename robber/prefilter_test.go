@@ -0,0 +1,61 @@
+package robber
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// benchmarkDiff generates a diff of given number of lines of ordinary code with a secret every 1000 lines.
+func benchmarkDiff(lines int) string {
+	var builder strings.Builder
+	for i := 0; i < lines; i++ {
+		switch {
+		case i%1000 == 0:
+			fmt.Fprintf(&builder, "+aws_access_key_id = \"AKIAIOSFODNN7%07d\"\n", i)
+		case i%2 == 0:
+			fmt.Fprintf(&builder, "+\tresult%d := compute(ctx, items[%d], \"value of item %d\")\n", i, i, i)
+		default:
+			fmt.Fprintf(&builder, " \tif err != nil {\n")
+		}
+	}
+	return builder.String()
+}
+
+// benchmarkMiddleware returns a Middleware with every rule of the default config.
+func benchmarkMiddleware(b *testing.B) *Middleware {
+	config, err := os.Open("../config/yarconfig.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	m := &Middleware{Flags: &Flags{Config: config, NoiseLevel: Bound{minNoise, maxNoise}}}
+	if err := ParseConfig(m); err != nil {
+		b.Fatal(err)
+	}
+	return m
+}
+
+func BenchmarkRegexDetector(b *testing.B) {
+	m := benchmarkMiddleware(b)
+	diff := benchmarkDiff(20000)
+	reponame, filepath := "repo", "file.go"
+	diffObject := &DiffObject{Diff: &diff, Reponame: &reponame, Filepath: &filepath}
+	detector := &RegexDetector{}
+	prefilter := m.Prefilter
+
+	b.Run("Prefilter", func(b *testing.B) {
+		m.Prefilter = prefilter
+		b.SetBytes(int64(len(diff)))
+		for i := 0; i < b.N; i++ {
+			detector.Detect(m, diffObject)
+		}
+	})
+	b.Run("NoPrefilter", func(b *testing.B) {
+		m.Prefilter = nil
+		b.SetBytes(int64(len(diff)))
+		for i := 0; i < b.N; i++ {
+			detector.Detect(m, diffObject)
+		}
+	})
+}
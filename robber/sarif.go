@@ -0,0 +1,228 @@
+package robber
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+const (
+	sarifSchema  = "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json"
+	sarifVersion = "2.1.0"
+	yarURI       = "https://github.com/nielsing/yar"
+	// Noise level given to the entropy check when mapping it to a SARIF level
+	entropyNoise = 3
+	// Noise level given to rules of custom detectors when mapping them to a SARIF level
+	customNoise = 4
+)
+
+var nonAlphanumeric = regexp.MustCompile("[^a-z0-9]+")
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+	Properties           sarifRuleProps     `json:"properties"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifRuleProps struct {
+	Noise int `json:"noise"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+	Properties          sarifResultProps  `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int          `json:"startLine"`
+	Snippet   sarifMessage `json:"snippet"`
+}
+
+type sarifResultProps struct {
+	Repository   string   `json:"repository"`
+	CommitHash   string   `json:"commitHash"`
+	Committer    string   `json:"committer"`
+	DateOfCommit string   `json:"dateOfCommit"`
+	ChangeType   string   `json:"changeType"`
+	Refs         []string `json:"refs,omitempty"`
+}
+
+// sarifRuleID turns the reason of a rule into a SARIF rule id, i.e. "AWS Secret Key" becomes "aws-secret-key".
+func sarifRuleID(reason string) string {
+	return strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(reason), "-"), "-")
+}
+
+// sarifLevel maps the noise level of a rule to a SARIF level. Noise levels from 0 to 4
+// are considered secrets while noise levels from 5 to 9 are considered reconnaissance info.
+func sarifLevel(noise int) string {
+	switch {
+	case noise <= 2:
+		return "error"
+	case noise <= 4:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+// getSarifRules returns a SARIF rule for every rule in use along with the index of each rule by its' reason.
+// Rules of custom detectors are only known by the reasons of their findings. Reasons which only differ
+// in case or punctuation get the same id, so the index of the rule is added to the id of later ones.
+func getSarifRules(m *Middleware, findings []*Finding) ([]sarifRule, map[string]int) {
+	rules := []sarifRule{}
+	indexes := make(map[string]int)
+	ids := make(map[string]bool)
+	addRule := func(reason string, noise int) {
+		if _, ok := indexes[reason]; ok {
+			return
+		}
+		id := sarifRuleID(reason)
+		if ids[id] {
+			id = fmt.Sprintf("%s-%d", id, len(rules))
+		}
+		ids[id] = true
+		indexes[reason] = len(rules)
+		rules = append(rules, sarifRule{
+			ID:                   id,
+			Name:                 reason,
+			ShortDescription:     sarifMessage{Text: reason},
+			DefaultConfiguration: sarifConfiguration{Level: sarifLevel(noise)},
+			Properties:           sarifRuleProps{Noise: noise},
+		})
+	}
+
+	for _, detector := range m.Detectors {
+		switch detector.(type) {
+		case *RegexDetector:
+			for _, rule := range m.Rules {
+				addRule(rule.Reason, rule.Noise)
+			}
+		case *EntropyDetector:
+			addRule(EntropyReason, entropyNoise)
+		}
+	}
+	for _, finding := range findings {
+		addRule(finding.Reason, customNoise)
+	}
+	return rules, indexes
+}
+
+// sarifResultMessage describes where the secret of a finding was found.
+func sarifResultMessage(finding *Finding, repoName string) string {
+	if finding.CommitHash == "" {
+		return finding.Reason + " found in " + repoName
+	}
+	return finding.Reason + " found in commit " + finding.CommitHash + " of " + repoName
+}
+
+// sarifFingerprint identifies a finding by its' rule, repository, commit, file and a hash of its' secret,
+// the same way findings are identified within the baseline.
+func sarifFingerprint(finding *Finding, secret string) string {
+	fingerprint := sha256.Sum256([]byte(Fingerprint(finding.Reason, finding.RepoName, finding.CommitHash, finding.Filepath, secret)))
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// SaveSarifFindings saves the given findings to a SARIF 2.1.0 log file.
+func SaveSarifFindings(m *Middleware, filename string, findings []*Finding) error {
+	rules, indexes := getSarifRules(m, findings)
+	results := []sarifResult{}
+	for _, finding := range findings {
+		index := indexes[finding.Reason]
+		repoName := strings.Replace(finding.RepoName, ".git", "", 1)
+		secret := finding.Diff[finding.Secret[0]:finding.Secret[1]]
+		results = append(results, sarifResult{
+			RuleID:    rules[index].ID,
+			RuleIndex: index,
+			Level:     rules[index].DefaultConfiguration.Level,
+			Message:   sarifMessage{Text: sarifResultMessage(finding, repoName)},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: finding.Filepath},
+					Region: sarifRegion{
+						StartLine: finding.LineNumber,
+						Snippet:   sarifMessage{Text: secret},
+					},
+				},
+			}},
+			PartialFingerprints: map[string]string{
+				"findingHash/v1": sarifFingerprint(finding, secret),
+			},
+			Properties: sarifResultProps{
+				Repository:   repoName,
+				CommitHash:   finding.CommitHash,
+				Committer:    finding.Committer,
+				DateOfCommit: finding.DateOfCommit,
+				ChangeType:   changeType(finding.Added),
+				Refs:         finding.Refs,
+			},
+		})
+	}
+
+	log := sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "yar",
+				InformationURI: yarURI,
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	}
+	content, _ := json.MarshalIndent(log, "", "  ")
+	return ioutil.WriteFile(filename, content, 0644)
+}
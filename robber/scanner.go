@@ -0,0 +1,201 @@
+package robber
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+const (
+	defaultNoise = "-3"
+	defaultDepth = 10000
+)
+
+// Options configures a Scanner, it holds the same settings as the CLI arguments of yar.
+// The config file at DefaultConfigPath is used if no Config is given.
+type Options struct {
+	// Org and User are scanned by listing their repositories on Github.
+	Org  string
+	User string
+	// Repos are paths to local repositories or URLs to clone them from.
+	Repos []string
+	// Paths are directories which are scanned as is, without any git history.
+	// Files ignored by .gitignore files within them are skipped if Gitignore is set.
+	Paths     []string
+	Gitignore bool
+	// Sources are scanned along with the repositories above.
+	Sources []Source
+	// Detectors default to a RegexDetector using the rules of the config.
+	Detectors []Detector
+	// Sinks receive all findings, findings are not written anywhere else.
+	Sinks []Sink
+	// Workers and CommitWorkers default to one per CPU while RepoTimeout and MaxRepoSize,
+	// given in bytes, are unlimited if zero.
+	Workers       int
+	CommitWorkers int
+	RepoTimeout   time.Duration
+	MaxRepoSize   int64
+
+	Config         string
+	Noise          string
+	Context        int
+	CommitDepth    int
+	AccessToken    string
+	Forks          bool
+	IncludeMembers bool
+	NoBare         bool
+	NoCache        bool
+	NoUpdate       bool
+	SkipDuplicates bool
+	Incremental    bool
+	DedupRepos     bool
+	AllRefs        bool
+	Branches       []string
+	Tags           []string
+	FailOn         []string
+	SinceCommit    string
+	UntilCommit    string
+	Since          time.Time
+	Baseline       string
+	UpdateBaseline bool
+}
+
+// Scanner scans sources for secrets with its' detectors and writes the findings to its' sinks.
+type Scanner struct {
+	m *Middleware
+}
+
+// flags converts the options to the flags the rest of yar is configured by.
+func (opts *Options) flags() (*Flags, error) {
+	if opts.Noise == "" {
+		opts.Noise = defaultNoise
+	}
+	level, err := parseNoiseLevel(opts.Noise)
+	if err != nil {
+		return nil, &ConfigError{Msg: "Invalid noise level", Err: err}
+	}
+	if opts.Context < 0 {
+		return nil, &ConfigError{Msg: "Context must be a non-negative integer"}
+	}
+	if opts.Workers < 0 || opts.CommitWorkers < 0 || opts.RepoTimeout < 0 || opts.MaxRepoSize < 0 {
+		return nil, &ConfigError{Msg: "Workers, commit workers, repo timeout and max repo size must be non-negative"}
+	}
+	if opts.CommitDepth == 0 {
+		opts.CommitDepth = defaultDepth
+	}
+	if opts.UpdateBaseline && opts.Baseline == "" {
+		return nil, &ConfigError{Msg: "Updating the baseline requires a baseline file"}
+	}
+	if opts.Config == "" {
+		opts.Config = DefaultConfigPath()
+	}
+	config, err := os.Open(opts.Config)
+	if err != nil {
+		return nil, &ConfigError{Msg: "Unable to open config " + opts.Config, Err: err}
+	}
+
+	failOnNoise, failOnRules := parseFailOn(opts.FailOn)
+	var empty string
+	var disabled bool
+	format := "json"
+	return &Flags{
+		Org:            &opts.Org,
+		User:           &opts.User,
+		Repo:           &empty,
+		Path:           &empty,
+		StdinDiff:      &disabled,
+		Save:           &empty,
+		Format:         &format,
+		Summary:        &empty,
+		Baseline:       &opts.Baseline,
+		CleanUp:        &empty,
+		Noise:          &opts.Noise,
+		Config:         config,
+		Entropy:        &disabled,
+		Both:           &disabled,
+		NoContext:      &disabled,
+		Forks:          &opts.Forks,
+		NoBare:         &opts.NoBare,
+		NoCache:        &opts.NoCache,
+		NoUpdate:       &opts.NoUpdate,
+		IncludeMembers: &opts.IncludeMembers,
+		SkipDuplicates: &opts.SkipDuplicates,
+		Incremental:    &opts.Incremental,
+		DedupRepos:     &opts.DedupRepos,
+		Gitignore:      &opts.Gitignore,
+		UpdateBaseline: &opts.UpdateBaseline,
+		AllRefs:        &opts.AllRefs,
+		Branches:       &opts.Branches,
+		Tags:           &opts.Tags,
+		FailOn:         &opts.FailOn,
+		SinceCommit:    &opts.SinceCommit,
+		UntilCommit:    &opts.UntilCommit,
+		SinceDate:      &empty,
+		Range:          &empty,
+		Context:        &opts.Context,
+		CommitDepth:    &opts.CommitDepth,
+		Workers:        &opts.Workers,
+		CommitWorkers:  &opts.CommitWorkers,
+		RepoTimeout:    &empty,
+		MaxRepoSize:    &empty,
+		NoiseLevel:     level,
+		Since:          opts.Since,
+		Timeout:        opts.RepoTimeout,
+		MaxSize:        opts.MaxRepoSize,
+		FailOnNoise:    failOnNoise,
+		FailOnRules:    failOnRules,
+	}, nil
+}
+
+// NewScanner creates a Scanner configured by the given options.
+// A ConfigError is returned if the options, the config or the baseline are invalid.
+func NewScanner(opts Options) (*Scanner, error) {
+	flags, err := opts.flags()
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMiddleware(flags, opts.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	for _, repo := range opts.Repos {
+		m.Sources = append(m.Sources, &RepoSource{Name: repo})
+	}
+	for _, path := range opts.Paths {
+		m.Sources = append(m.Sources, &PathSource{Dir: path, Gitignore: opts.Gitignore})
+	}
+	m.Sources = append(m.Sources, opts.Sources...)
+	m.Detectors = opts.Detectors
+	if len(m.Detectors) == 0 {
+		m.Detectors = []Detector{&RegexDetector{}}
+	}
+	m.Sinks = opts.Sinks
+	return &Scanner{m: m}, nil
+}
+
+// Scan scans all sources and closes the sinks once it is done or the given context is done.
+// An error is returned if the repositories of the user or organization can't be listed,
+// if the scan was cancelled or if a sink can't be closed. Errors of single sources are
+// available through Errors.
+func (s *Scanner) Scan(ctx context.Context) error {
+	err := s.m.Start(ctx)
+	if closeErr := s.m.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Skipped returns the repositories which were skipped, or only partially scanned, for exceeding a limit.
+func (s *Scanner) Skipped() []*SkippedRepo {
+	return s.m.Skipped
+}
+
+// Summary sums up the scan, it can also be called while the scan is running.
+func (s *Scanner) Summary() *Summary {
+	return s.m.Summary()
+}
+
+// Errors returns the errors of the sources which couldn't be scanned.
+func (s *Scanner) Errors() []error {
+	return s.m.Errors
+}
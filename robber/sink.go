@@ -0,0 +1,129 @@
+package robber
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/fatih/color"
+)
+
+// Sink receives the findings of a scan. Close is called once the scan is over.
+type Sink interface {
+	Write(m *Middleware, finding *Finding) error
+	Close(m *Middleware) error
+}
+
+// ExposureSink is a Sink which also receives the exposure of the reported secrets of each scanned repository.
+type ExposureSink interface {
+	Sink
+	WriteExposures(m *Middleware, reponame string, exposures map[string]*Exposure) error
+}
+
+// ConsoleSink prints findings to the output.
+type ConsoleSink struct{}
+
+// Write prints a given finding along with its' context.
+func (s *ConsoleSink) Write(m *Middleware, finding *Finding) error {
+	m.Logger.LogFinding(finding, m)
+	return nil
+}
+
+// WriteExposures prints the exposure of each reported secret of a given repository.
+func (s *ConsoleSink) WriteExposures(m *Middleware, reponame string, exposures map[string]*Exposure) error {
+	m.Logger.LogExposures(reponame, exposures)
+	return nil
+}
+
+// Close does nothing as findings have already been printed.
+func (s *ConsoleSink) Close(m *Middleware) error {
+	return nil
+}
+
+// CompactSink prints each finding on a single line, which is how yar outputs findings as a hook.
+type CompactSink struct{}
+
+// Write prints the location, reason and secret of a given finding.
+func (s *CompactSink) Write(m *Middleware, finding *Finding) error {
+	m.Logger.LogCompactFinding(finding)
+	return nil
+}
+
+// Close does nothing as findings have already been printed.
+func (s *CompactSink) Close(m *Middleware) error {
+	return nil
+}
+
+// JSONSink saves all findings to a JSON file once the scan is over.
+type JSONSink struct {
+	Filename string
+	findings []*Finding
+}
+
+// Write keeps a hold of a given finding until the scan is over.
+func (s *JSONSink) Write(m *Middleware, finding *Finding) error {
+	s.findings = append(s.findings, finding)
+	return nil
+}
+
+// Close saves all findings along with the exposure of their secrets.
+func (s *JSONSink) Close(m *Middleware) error {
+	var savedFindings []*jsonFinding
+	for _, finding := range s.findings {
+		savedFindings = append(savedFindings, newJSONFinding(finding, true))
+	}
+	content, _ := json.MarshalIndent(savedFindings, "", "  ")
+	return ioutil.WriteFile(s.Filename, content, 0644)
+}
+
+// JSONLSink writes each finding as a single line of JSON as soon as it is found.
+type JSONLSink struct {
+	file *os.File
+}
+
+// NewJSONLSink creates the file findings are streamed to in the JSON Lines format.
+// Findings are streamed to stdout if the file is named "-", in which case all
+// other output is written to stderr.
+func NewJSONLSink(filename string) (*JSONLSink, error) {
+	if filename == "-" {
+		color.Output = color.Error
+		return &JSONLSink{file: os.Stdout}, nil
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, &ConfigError{Msg: "Unable to create file " + filename, Err: err}
+	}
+	return &JSONLSink{file: file}, nil
+}
+
+// Write writes a given finding as a single line of JSON.
+func (s *JSONLSink) Write(m *Middleware, finding *Finding) error {
+	content, _ := json.Marshal(newJSONFinding(finding, false))
+	_, err := s.file.Write(append(content, '\n'))
+	return err
+}
+
+// Close closes the file unless findings were streamed to stdout.
+func (s *JSONLSink) Close(m *Middleware) error {
+	if s.file == os.Stdout {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// SarifSink saves all findings to a SARIF 2.1.0 log file once the scan is over.
+type SarifSink struct {
+	Filename string
+	findings []*Finding
+}
+
+// Write keeps a hold of a given finding until the scan is over.
+func (s *SarifSink) Write(m *Middleware, finding *Finding) error {
+	s.findings = append(s.findings, finding)
+	return nil
+}
+
+// Close saves all findings to the SARIF log.
+func (s *SarifSink) Close(m *Middleware) error {
+	return SaveSarifFindings(m, s.Filename, s.findings)
+}
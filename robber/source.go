@@ -0,0 +1,244 @@
+package robber
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// Number of bytes looked at when checking whether a file is binary, the same as git does.
+const binaryCheckSize = 8000
+
+// Source provides the diffs which are scanned for secrets.
+// A source calls AnalyzeDiff on each of its' diffs and stops once the given context is done.
+// Sources which implement fmt.Stringer are shown by name in the progress of a scan.
+type Source interface {
+	Scan(ctx context.Context, m *Middleware) error
+}
+
+// RepoSource provides the diffs of the commit history of a git repository.
+// Name is either a path to a local repository or a URL to clone the repository from.
+type RepoSource struct {
+	Name string
+}
+
+// Scan scans the commits of the repository.
+func (r *RepoSource) Scan(ctx context.Context, m *Middleware) error {
+	err := ScanRepo(ctx, m, r.Name)
+	if err == transport.ErrEmptyRemoteRepository {
+		m.Logger.LogWarn("%s is empty\n", r.Name)
+	}
+	return err
+}
+
+func (r *RepoSource) String() string {
+	return r.Name
+}
+
+// PathSource provides the contents of every file within a directory, without any git history.
+// Files matching the file blacklist are skipped, as well as files ignored by .gitignore files if Gitignore is set.
+type PathSource struct {
+	Dir       string
+	Gitignore bool
+}
+
+// Scan walks the directory and analyzes the contents of each text file. Findings of a
+// PathSource have no commit, their file path is relative to the directory.
+func (p *PathSource) Scan(ctx context.Context, m *Middleware) error {
+	if info, err := os.Stat(p.Dir); err != nil || !info.IsDir() {
+		return &NotFoundError{Name: p.Dir, Err: err}
+	}
+	var matcher gitignore.Matcher
+	if p.Gitignore {
+		patterns, err := gitignore.ReadPatterns(osfs.New(p.Dir), nil)
+		if err != nil {
+			return err
+		}
+		matcher = gitignore.NewMatcher(patterns)
+	}
+
+	return filepath.Walk(p.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			m.Logger.LogWarn("Unable to read %s: %s\n", path, err)
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		relPath, _ := filepath.Rel(p.Dir, path)
+		relPath = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			if info.Name() == ".git" || (matcher != nil && relPath != "." && matcher.Match(strings.Split(relPath, "/"), true)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || blacklistedFile(m, relPath) {
+			return nil
+		}
+		if matcher != nil && matcher.Match(strings.Split(relPath, "/"), false) {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			m.Logger.LogWarn("Unable to read %s: %s\n", path, err)
+			return nil
+		}
+		if isBinary(content) {
+			return nil
+		}
+		m.Stats.AddFile()
+		AnalyzeDiff(m, NewDiffObject(nil, newHunk(string(content), 1, true), &p.Dir, &relPath, nil))
+		return nil
+	})
+}
+
+func (p *PathSource) String() string {
+	return p.Dir
+}
+
+// StagedSource provides the lines added by the changes staged for the next commit of the repository
+// in the current directory, which is what the pre-commit hook of yar scans.
+type StagedSource struct{}
+
+// Scan diffs the staged version of each file against its' version at HEAD and analyzes the added lines.
+// Findings of a StagedSource have no commit, their file path is relative to the root of the repository.
+func (s *StagedSource) Scan(ctx context.Context, m *Middleware) error {
+	repo, err := openWorkingRepo()
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	root := worktree.Filesystem.Root()
+	idx, err := readIndex(repo)
+	if err != nil {
+		return fmt.Errorf("Unable to read the index of %s: %w", root, err)
+	}
+	head, err := headTree(repo)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range idx.Entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Entries of unresolved merge conflicts have a non-zero stage and can't be committed.
+		// Note that go-git's index.Merged is wrongly 1, which is the stage of the common ancestor.
+		if entry.Stage != 0 || blacklistedFile(m, entry.Name) {
+			continue
+		}
+		var old string
+		if file, err := head.File(entry.Name); err == nil {
+			if file.Hash == entry.Hash {
+				continue
+			}
+			if old, err = file.Contents(); err != nil {
+				m.Logger.LogWarn("Unable to read %s at HEAD: %s\n", entry.Name, err)
+				continue
+			}
+		}
+		content, err := readBlob(repo, entry.Hash)
+		if err != nil {
+			m.Logger.LogWarn("Unable to read the staged version of %s: %s\n", entry.Name, err)
+			continue
+		}
+		if isBinary(content) {
+			continue
+		}
+		m.Stats.AddFile()
+		filepath := entry.Name
+		for _, hunk := range addedHunks(old, string(content)) {
+			AnalyzeDiff(m, NewDiffObject(nil, hunk, &root, &filepath, nil))
+		}
+	}
+	return nil
+}
+
+func (s *StagedSource) String() string {
+	return "staged changes"
+}
+
+// PushSource provides the lines added by the commits of a push, which is what the pre-receive hook
+// of yar scans. It is meant to be scanned within the repository a pre-receive hook runs in.
+type PushSource struct {
+	Updates []*RefUpdate
+}
+
+// Scan analyzes the lines added by each pushed commit which isn't reachable from any reference of the
+// repository yet, from the oldest commit to the newest.
+func (p *PushSource) Scan(ctx context.Context, m *Middleware) error {
+	repo, reponame, err := openPushedRepo()
+	if err != nil {
+		return err
+	}
+	// go-git isn't safe for concurrent use, so history is walked on a repository of its' own
+	history, _, err := openPushedRepo()
+	if err != nil {
+		return err
+	}
+	commits, errc, err := GetPushedCommits(ctx, history, reponame, p.Updates)
+	if err != nil {
+		return err
+	}
+	// Removing a secret is never a reason to reject a push
+	if err := AnalyzeCommits(ctx, m, repo, reponame, commits, NewDiffCache(), true); err != nil {
+		return err
+	}
+	return <-errc
+}
+
+func (p *PushSource) String() string {
+	return "pushed commits"
+}
+
+// DiffSource provides the hunks of unified diff text, such as the output of git diff or a patch file.
+// Name is shown as the repository of its' findings, which have no commit.
+type DiffSource struct {
+	Reader io.Reader
+	Name   string
+}
+
+// Scan parses the diff and analyzes the hunks of each file.
+func (d *DiffSource) Scan(ctx context.Context, m *Middleware) error {
+	files, err := ParsePatch(d.Reader)
+	if err != nil {
+		return fmt.Errorf("Unable to read diff from %s: %w", d.Name, err)
+	}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if blacklistedFile(m, file.Path) {
+			continue
+		}
+		m.Stats.AddFile()
+		filepath := file.Path
+		for _, hunk := range file.Hunks {
+			AnalyzeDiff(m, NewDiffObject(nil, hunk, &d.Name, &filepath, nil))
+		}
+	}
+	return nil
+}
+
+func (d *DiffSource) String() string {
+	return d.Name
+}
+
+// isBinary checks whether given file contents are binary, in the same way as git does.
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:Min(len(content), binaryCheckSize)], 0) != -1
+}
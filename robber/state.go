@@ -0,0 +1,91 @@
+package robber
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const stateFilename = "yarstate.json"
+
+// ScanState keeps track of the last scanned commit of each reference within a repository
+// along with a hash of the rules it was scanned with.
+type ScanState struct {
+	RulesHash string            `json:"RulesHash"`
+	Refs      map[string]string `json:"Refs"`
+}
+
+// rulesHash returns a hash of everything which affects what yar finds in a diff,
+// that is the rules along with their keywords, the file blacklist and the detectors in use.
+func rulesHash(m *Middleware) string {
+	hash := sha256.New()
+	for _, rule := range m.Rules {
+		fmt.Fprintf(hash, "%s\x00%s\x00", rule.Reason, rule.Regex)
+		if rule.Multiline {
+			fmt.Fprint(hash, "multiline\x00")
+		}
+		for _, keyword := range rule.Keywords {
+			fmt.Fprintf(hash, "keyword\x00%s\x00", keyword)
+		}
+	}
+	for _, rule := range m.Blacklist {
+		fmt.Fprintf(hash, "%s\x00", rule)
+	}
+	for _, detector := range m.Detectors {
+		fmt.Fprintf(hash, "%T\x00", detector)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// getStatePath returns the path of the state file of a given repo.
+// The state file is kept within the git folder of the repo so it is removed along with the cache.
+func getStatePath(reponame string) string {
+	dir, _ := GetDir(reponame)
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		dir = filepath.Join(dir, ".git")
+	}
+	return filepath.Join(dir, stateFilename)
+}
+
+// LoadScanState loads the state of previous scans of a given repo.
+// An empty state is returned if the --incremental flag wasn't given, if the repo
+// has not been scanned before or if it was scanned with a different set of rules.
+func LoadScanState(m *Middleware, reponame string) *ScanState {
+	state := &ScanState{
+		RulesHash: rulesHash(m),
+		Refs:      make(map[string]string),
+	}
+	if !*m.Flags.Incremental {
+		return state
+	}
+
+	content, err := ioutil.ReadFile(getStatePath(reponame))
+	if err != nil {
+		return state
+	}
+	var saved ScanState
+	if err := json.Unmarshal(content, &saved); err != nil || saved.RulesHash != state.RulesHash {
+		return state
+	}
+	for ref, hash := range saved.Refs {
+		state.Refs[ref] = hash
+	}
+	return state
+}
+
+// SaveScanState saves the state of a finished scan of a given repo.
+// Nothing is saved if the --incremental flag wasn't given or if only a part of
+// the history was scanned due to the --since-commit, --range or --since-date flags.
+func SaveScanState(m *Middleware, reponame string, state *ScanState) {
+	if !*m.Flags.Incremental || *m.Flags.SinceCommit != "" || !m.Flags.Since.IsZero() {
+		return
+	}
+	content, _ := json.MarshalIndent(state, "", "  ")
+	if err := ioutil.WriteFile(getStatePath(reponame), content, 0644); err != nil {
+		m.Logger.LogWarn("Unable to save scan state of %s: %s\n", reponame, err)
+	}
+}
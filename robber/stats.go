@@ -0,0 +1,230 @@
+package robber
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats keeps count of the progress of a scan. It is safe to use from multiple go routines.
+type Stats struct {
+	sync.Mutex
+	Start    time.Time
+	End      time.Time
+	Queued   int
+	Done     int
+	Scanned  int
+	Empty    int
+	Commits  int
+	Files    int
+	Failing  int
+	Findings map[string]int
+	Working  map[int]string
+}
+
+// Exit codes of yar.
+const (
+	// ExitClean means the scan finished without any failing findings.
+	ExitClean = 0
+	// ExitFindings means the scan found secrets which fail it, see the --fail-on flag.
+	ExitFindings = 1
+	// ExitError means yar was used incorrectly, the scan was interrupted or a repo couldn't be scanned.
+	ExitError = 2
+)
+
+// Summary sums up a finished scan, it is what yar saves with the --summary flag.
+type Summary struct {
+	ReposScanned    int            `json:"ReposScanned"`
+	ReposSkipped    int            `json:"ReposSkipped"`
+	ReposEmpty      int            `json:"ReposEmpty"`
+	ReposFailed     int            `json:"ReposFailed"`
+	CommitsAnalyzed int            `json:"CommitsAnalyzed"`
+	FilesAnalyzed   int            `json:"FilesAnalyzed"`
+	Findings        int            `json:"Findings"`
+	FailingFindings int            `json:"FailingFindings"`
+	FindingsPerRule map[string]int `json:"FindingsPerRule"`
+	ElapsedSeconds  float64        `json:"ElapsedSeconds"`
+	Skipped         []*SkippedRepo `json:"Skipped"`
+	Errors          []string       `json:"Errors"`
+}
+
+// NewStats returns empty stats of a scan.
+func NewStats() *Stats {
+	return &Stats{
+		Findings: make(map[string]int),
+		Working:  make(map[int]string),
+	}
+}
+
+// Begin marks the scan as started.
+func (s *Stats) Begin() {
+	s.Lock()
+	defer s.Unlock()
+	s.Start = time.Now()
+}
+
+// AddQueued counts a source which was queued for scanning.
+func (s *Stats) AddQueued() {
+	s.Lock()
+	defer s.Unlock()
+	s.Queued++
+}
+
+// AddScanned counts a source which was scanned.
+func (s *Stats) AddScanned() {
+	s.Lock()
+	defer s.Unlock()
+	s.Scanned++
+}
+
+// AddEmpty counts an empty repository.
+func (s *Stats) AddEmpty() {
+	s.Lock()
+	defer s.Unlock()
+	s.Empty++
+}
+
+// AddCommit counts a commit which was analyzed.
+func (s *Stats) AddCommit() {
+	s.Lock()
+	defer s.Unlock()
+	s.Commits++
+}
+
+// AddFile counts a file which was analyzed outside of git history.
+func (s *Stats) AddFile() {
+	s.Lock()
+	defer s.Unlock()
+	s.Files++
+}
+
+// AddFinding counts a finding of the rule with the given reason and whether it fails the scan.
+func (s *Stats) AddFinding(reason string, failing bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.Findings[reason]++
+	if failing {
+		s.Failing++
+	}
+}
+
+// SetWorking sets the source a given worker is scanning.
+func (s *Stats) SetWorking(id int, name string) {
+	s.Lock()
+	defer s.Unlock()
+	s.Working[id] = name
+}
+
+// FinishWorking counts the source a given worker was scanning as done.
+func (s *Stats) FinishWorking(id int) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.Working, id)
+	s.Done++
+}
+
+// Finish marks the scan as finished.
+func (s *Stats) Finish() {
+	s.Lock()
+	defer s.Unlock()
+	s.End = time.Now()
+}
+
+// elapsed returns the time the scan took, or has taken so far if it is still running.
+func (s *Stats) elapsed() time.Duration {
+	if s.End.IsZero() {
+		return time.Since(s.Start)
+	}
+	return s.End.Sub(s.Start)
+}
+
+// Progress returns the lines describing the progress of the scan, that is the number of
+// sources done, commits analyzed and findings, followed by the source each worker is scanning.
+func (s *Stats) Progress() []string {
+	s.Lock()
+	defer s.Unlock()
+	findings := 0
+	for _, count := range s.Findings {
+		findings += count
+	}
+	files := ""
+	if s.Files != 0 {
+		files = fmt.Sprintf(" | Files: %d", s.Files)
+	}
+	lines := []string{fmt.Sprintf("Repos: %d/%d done | Commits: %d%s | Findings: %d | Elapsed: %s",
+		s.Done, s.Queued, s.Commits, files, findings, s.elapsed().Round(time.Second))}
+
+	ids := make([]int, 0, len(s.Working))
+	for id := range s.Working {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		lines = append(lines, fmt.Sprintf("  [%d] %s", id, s.Working[id]))
+	}
+	return lines
+}
+
+// Summary sums up the scan along with the repositories which were skipped and the errors which occurred.
+func (m *Middleware) Summary() *Summary {
+	m.Stats.Lock()
+	defer m.Stats.Unlock()
+	m.Lock()
+	defer m.Unlock()
+	summary := &Summary{
+		ReposScanned:    m.Stats.Scanned,
+		ReposSkipped:    len(m.Skipped),
+		ReposEmpty:      m.Stats.Empty,
+		ReposFailed:     len(m.Errors),
+		CommitsAnalyzed: m.Stats.Commits,
+		FilesAnalyzed:   m.Stats.Files,
+		FailingFindings: m.Stats.Failing,
+		FindingsPerRule: make(map[string]int),
+		ElapsedSeconds:  m.Stats.elapsed().Seconds(),
+		Skipped:         append([]*SkippedRepo{}, m.Skipped...),
+		Errors:          []string{},
+	}
+	for reason, count := range m.Stats.Findings {
+		summary.FindingsPerRule[reason] = count
+		summary.Findings += count
+	}
+	for _, err := range m.Errors {
+		summary.Errors = append(summary.Errors, err.Error())
+	}
+	return summary
+}
+
+// ExitCode returns the exit code of the summarized scan.
+func (s *Summary) ExitCode() int {
+	if s.ReposFailed != 0 {
+		return ExitError
+	}
+	if s.FailingFindings != 0 {
+		return ExitFindings
+	}
+	return ExitClean
+}
+
+// failsOn checks whether a given finding fails the scan. Every finding fails the scan unless
+// the --fail-on flag was given, in which case only findings of the given noise levels or rules do.
+func failsOn(m *Middleware, finding *Finding) bool {
+	if len(m.Flags.FailOnNoise) == 0 && len(m.Flags.FailOnRules) == 0 {
+		return true
+	}
+	noise := ruleNoise(m, finding.Reason)
+	for _, level := range m.Flags.FailOnNoise {
+		if noise >= level.Lower && noise <= level.Upper {
+			return true
+		}
+	}
+	return m.Flags.FailOnRules[finding.Reason]
+}
+
+// SaveSummary saves a given summary to a JSON file.
+func SaveSummary(summary *Summary, filename string) error {
+	content, _ := json.MarshalIndent(summary, "", "  ")
+	return ioutil.WriteFile(filename, content, 0644)
+}
@@ -2,6 +2,7 @@ package robber
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"math"
 	"net/http"
@@ -16,37 +17,30 @@ const (
 	envTokenVariable = "YAR_GITHUB_TOKEN"
 )
 
-var (
-	count = 0
-)
-
 // CleanUp deletes all temp directories which were created for cloning of repositories.
-func CleanUp(m *Middleware) {
+func CleanUp(m *Middleware) error {
 	err := os.RemoveAll(filepath.Join(os.TempDir(), "yar", *m.Flags.CleanUp))
 	if err != nil {
-		m.Logger.LogWarn("Unable to remove the cache folder!")
+		return fmt.Errorf("Unable to remove the cache folder: %s", err)
 	}
-	os.Exit(0)
+	return nil
 }
 
-// HandleSigInt captures the SIGINT signal and removes the cache folder.
-// This is done to avoid nil pointers for future runs of yar.
-func HandleSigInt(m *Middleware, sigc chan os.Signal, kill chan<- bool, finished <-chan bool, cleanup chan<- bool) {
-	for {
-		select {
-		case <-sigc:
-			count++
-			if count == 2 {
-				os.Exit(1)
-			}
-			m.Logger.LogInfo("Killing all threads!\n")
-			m.Logger.LogInfo("Press Ctrl-C again to force quit\n")
-			kill <- true
-			<-finished
-			CleanUp(m)
-			cleanup <- true
-		}
-	}
+// HandleSigInt captures the SIGINT signal and cancels the scan.
+// A second SIGINT force quits yar with the exit code for errors.
+func HandleSigInt(m *Middleware, sigc <-chan os.Signal, cancel context.CancelFunc) {
+	<-sigc
+	m.Logger.LogInfo("Killing all threads!\n")
+	m.Logger.LogInfo("Press Ctrl-C again to force quit\n")
+	cancel()
+	<-sigc
+	os.Exit(ExitError)
+}
+
+// IsTerminal checks whether a given file is a terminal.
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
 // GetDir returns the respective directory of a given cloneurl and whether it exists.
@@ -102,54 +96,15 @@ func EntropyCheck(data string, values string) float64 {
 	return entropy
 }
 
-// FindContext finds context lines of an entropy finding.
-func FindContext(m *Middleware, diff string, secret string) (string, []int) {
-	lines := strings.Split(diff, "\n")
-	numOfLines := len(lines)
-
-	for lineNum, line := range lines {
-		if strings.Contains(line, secret) {
-			start, end := Max(0, lineNum-*m.Flags.Context), Min(numOfLines, lineNum+*m.Flags.Context+1)
-			context := lines[start:end]
-			newDiff := strings.Join(context, "\n")
-			index := strings.Index(newDiff, secret)
-			return newDiff, []int{index, index + len(secret)}
-		}
-	}
-	return "", nil
-}
-
-// PrintEntropyFinding checks for a given validString set whether the threshold is broken and if it is
-// finds the context around the secret of the diff and prints it along with the secret.
-func PrintEntropyFinding(validStrings []string, m *Middleware, diffObject *DiffObject, threshold float64) {
-	for _, validString := range validStrings {
-		entropy := EntropyCheck(validString, B64chars)
-		if entropy > threshold {
-			context, indexes := FindContext(m, *diffObject.Diff, validString)
-			secretString := context[indexes[0]:indexes[1]]
-			if *m.Flags.SkipDuplicates && !m.SecretExists(*diffObject.Reponame, secretString) {
-				m.AddSecret(*diffObject.Reponame, secretString)
-				finding := NewFinding("Entropy Check", indexes, diffObject)
-				m.Logger.LogFinding(finding, m, context)
-			} else if !*m.Flags.SkipDuplicates {
-				finding := NewFinding("Entropy Check", indexes, diffObject)
-				m.Logger.LogFinding(finding, m, context)
-			}
-		}
-	}
-}
-
-// GetAccessToken retreives access token from env variables and returns an oauth2 client.
-func GetAccessToken(m *Middleware) (string, *http.Client) {
-	accessToken := os.Getenv(envTokenVariable)
+// GetAccessClient returns an oauth2 client for a given access token, or nil if none was given.
+func GetAccessClient(accessToken string) *http.Client {
 	if accessToken == "" {
-		return "", nil
+		return nil
 	}
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: accessToken},
 	)
-	tc := oauth2.NewClient(context.Background(), ts)
-	return accessToken, tc
+	return oauth2.NewClient(context.Background(), ts)
 }
 
 // GetGoPath returns user's GOPATH env variable.
@@ -186,6 +141,18 @@ func Min(a, b int) int {
 	return b
 }
 
+// FormatSize formats a given number of bytes in the largest unit which fits it, i.e. 1.5GB.
+func FormatSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", value), ".0") + units[unit]
+}
+
 // WriteToFile writes given string array to the given filename with each
 // instance in the array being line seperated
 func WriteToFile(filename string, values []*string) error {